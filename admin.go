@@ -0,0 +1,270 @@
+// admin.go
+//
+// Administrative endpoints for managing role documents. Request bodies are decoded
+// strictly so that typos in field names are rejected instead of silently producing
+// ineffective permissions.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultBodyLimit is the maximum accepted request body size when BODY_LIMIT_BYTES is unset.
+const defaultBodyLimit = 1 << 20
+
+/*
+bodyLimit returns the maximum request body size in bytes from BODY_LIMIT_BYTES.
+*/
+func bodyLimit() int {
+	v := os.Getenv("BODY_LIMIT_BYTES")
+	if v == "" {
+		return defaultBodyLimit
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid BODY_LIMIT_BYTES %q: must be a positive integer", v)
+	}
+	return n
+}
+
+/*
+decodeStrictJSON decodes a JSON body into v, rejecting unknown fields (e.g. "region" instead
+of "regions") and trailing data after the top-level value.
+*/
+func decodeStrictJSON(body []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("unexpected data after JSON body")
+	}
+	return nil
+}
+
+/*
+upsertRoleHandler creates or replaces the role identified by :id with the JSON body and
+drops it from the caches so the change applies on the next request, on every replica when
+CACHE_BACKEND=redis.
+*/
+func upsertRoleHandler(c *fiber.Ctx) error {
+	editor, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	roleID := c.Params("id")
+	var role Role
+	if err := decodeStrictJSON(c.Body(), &role); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Invalid role body",
+			"detail": err.Error(),
+		})
+	}
+	if role.RoleID != "" && role.RoleID != roleID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("role_id %q does not match path id %q", role.RoleID, roleID),
+		})
+	}
+	role.RoleID = roleID
+	for _, perm := range role.Permissions {
+		if err := validateConditions(perm.Conditions); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":  "Invalid role body",
+				"detail": fmt.Sprintf("permission %q: %v", perm.Path, err),
+			})
+		}
+	}
+	if issues := validateRole(role); len(issues) > 0 && roleValidationStrict {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Invalid role permissions",
+			"issues": issues,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = mongoDB.Collection("roles").ReplaceOne(ctx, bson.M{"role_id": roleID}, role,
+		options.Replace().SetUpsert(true))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":  "Database write error",
+			"detail": err.Error(),
+		})
+	}
+	roleCaches.invalidate(roleID)
+	logInfo("role updated", "role", roleID, "editor", logSafe(editor.ID))
+	return c.JSON(role)
+}
+
+// simulateRequest is the body of POST /admin/roles/:id/simulate.
+type simulateRequest struct {
+	Permissions []Permission `json:"permissions"`
+	Path        string       `json:"path"`
+	Country     string       `json:"country"`
+	// Attributes are optional request attributes for permissions with conditions.
+	Attributes map[string]interface{} `json:"attributes"`
+	// Audience optionally simulates a token issued for, and a route targeting, that audience.
+	Audience string `json:"audience"`
+}
+
+/*
+simulateRoleHandler evaluates a target path+country against both the stored definition of
+role :id and a proposed permission set, reporting whether the change grants or revokes
+access. Nothing is persisted. A role that does not exist yet is treated as granting nothing.
+*/
+func simulateRoleHandler(c *fiber.Ctx) error {
+	roleID := c.Params("id")
+	var body simulateRequest
+	if err := decodeStrictJSON(c.Body(), &body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Invalid simulation body",
+			"detail": err.Error(),
+		})
+	}
+	if body.Path == "" || body.Country == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "path and country are required"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	current := Role{RoleID: roleID}
+	err := mongoDB.Collection("roles").FindOne(ctx, bson.M{"role_id": roleID}).Decode(&current)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":  "Database read error",
+			"detail": err.Error(),
+		})
+	}
+	proposed := Role{RoleID: roleID, Permissions: body.Permissions}
+
+	req := Requirement{Path: body.Path, Country: normalizeCountry(body.Country), Attributes: body.Attributes, Audience: body.Audience}
+	currentUser, proposedUser := userFromRoles("simulation", []Role{current}), userFromRoles("simulation", []Role{proposed})
+	if body.Audience != "" {
+		currentUser.Audiences = []string{body.Audience}
+		proposedUser.Audiences = []string{body.Audience}
+	}
+	before := IsAllowed(currentUser, req)
+	after := IsAllowed(proposedUser, req)
+
+	change := "unchanged"
+	if !before && after {
+		change = "granted"
+	} else if before && !after {
+		change = "revoked"
+	}
+	return c.JSON(SimulateResponse{
+		RoleID:   roleID,
+		Path:     req.Path,
+		Country:  req.Country,
+		Current:  before,
+		Proposed: after,
+		Change:   change,
+	})
+}
+
+// accessSubject identifies a user for DiffAccess by the role ids their token would carry.
+type accessSubject struct {
+	ID    string   `json:"id"`
+	Roles []string `json:"roles"`
+}
+
+// maxAccessDiffPairs caps paths x countries of one POST /admin/access/diff (ACCESS_DIFF_MAX_PAIRS).
+var maxAccessDiffPairs = 1000
+
+/*
+initAccessDiff reads ACCESS_DIFF_MAX_PAIRS.
+*/
+func initAccessDiff() {
+	if v := os.Getenv("ACCESS_DIFF_MAX_PAIRS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid ACCESS_DIFF_MAX_PAIRS %q: must be a positive integer", v)
+		}
+		maxAccessDiffPairs = n
+	}
+}
+
+// diffAccessRequest is the body of POST /admin/access/diff.
+type diffAccessRequest struct {
+	A         accessSubject `json:"a"`
+	B         accessSubject `json:"b"`
+	Paths     []string      `json:"paths"`
+	Countries []string      `json:"countries"`
+}
+
+/*
+subjectUser builds the User a token with the subject's roles would resolve to. Disabled
+roles are skipped as in extractUser; an unknown role id is an error.
+*/
+func subjectUser(ctx context.Context, subject accessSubject) (*User, error) {
+	var roles []Role
+	for _, roleID := range subject.Roles {
+		role, err := loadRole(ctx, roleID)
+		if err != nil {
+			return nil, fmt.Errorf("role %q: %v", roleID, err)
+		}
+		if !role.Disabled {
+			roles = append(roles, role)
+		}
+	}
+	return userFromRoles(subject.ID, roles), nil
+}
+
+/*
+diffAccessHandler compares two users' effective access over a path x country matrix and
+returns only the pairs where one is allowed and the other is not. The matrix is capped at
+maxAccessDiffPairs pairs.
+*/
+func diffAccessHandler(c *fiber.Ctx) error {
+	var body diffAccessRequest
+	if err := decodeStrictJSON(c.Body(), &body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Invalid diff body",
+			"detail": err.Error(),
+		})
+	}
+	if len(body.Paths) == 0 || len(body.Countries) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "paths and countries are required"})
+	}
+	if len(body.Paths)*len(body.Countries) > maxAccessDiffPairs {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("at most %d path x country pairs may be compared per request", maxAccessDiffPairs),
+		})
+	}
+	countries := make([]string, len(body.Countries))
+	for i, country := range body.Countries {
+		countries[i] = normalizeCountry(country)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a, err := subjectUser(ctx, body.A)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot resolve user a", "detail": err.Error()})
+	}
+	b, err := subjectUser(ctx, body.B)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot resolve user b", "detail": err.Error()})
+	}
+
+	return c.JSON(AccessDiffResponse{
+		A:           body.A.ID,
+		B:           body.B.ID,
+		Evaluated:   len(body.Paths) * len(countries),
+		Differences: DiffAccess(a, b, body.Paths, countries),
+	})
+}
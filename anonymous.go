@@ -0,0 +1,68 @@
+// anonymous.go
+//
+// Optional anonymous access governed by RBAC. When ALLOW_ANONYMOUS_ROLE names a role,
+// requests without an Authorization header are evaluated as an "anonymous" user holding
+// only that role, instead of being rejected with 401. Requests that do carry a token are
+// always evaluated as the token's user, and a malformed or invalid token is still a 401.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// anonymousUserID is the User.ID (and logged preferred_username) of anonymous callers.
+const anonymousUserID = "anonymous"
+
+// anonymousRoleID is the role granted to tokenless requests. Empty disables anonymous access.
+var anonymousRoleID string
+
+/*
+initAnonymousAccess reads ALLOW_ANONYMOUS_ROLE.
+*/
+func initAnonymousAccess() {
+	anonymousRoleID = os.Getenv("ALLOW_ANONYMOUS_ROLE")
+	if anonymousRoleID != "" {
+		logInfo("anonymous access enabled", "role", anonymousRoleID)
+	}
+}
+
+/*
+isAnonymousRequest reports whether the request should be evaluated as the anonymous user:
+anonymous access is enabled and no Authorization header was sent at all.
+*/
+func isAnonymousRequest(c *fiber.Ctx) bool {
+	return anonymousRoleID != "" && c.Get(fiber.HeaderAuthorization) == ""
+}
+
+/*
+anonymousClaims returns the stand-in claims used to log and evaluate anonymous requests.
+*/
+func anonymousClaims() jwt.MapClaims {
+	return jwt.MapClaims{"preferred_username": anonymousUserID}
+}
+
+/*
+anonymousUser builds the anonymous User from the configured role, loaded through the role
+cache like any other role. A missing or disabled anonymous role grants nothing.
+*/
+func anonymousUser() (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	role, err := loadRole(ctx, anonymousRoleID)
+	if err != nil {
+		logWarn("role lookup failed", "user", anonymousUserID, "role", anonymousRoleID, "error", err)
+		return nil, fmt.Errorf("permission check failed: could not resolve user roles")
+	}
+	if role.Disabled {
+		return userFromRoles(anonymousUserID, nil), nil
+	}
+	return userFromRoles(anonymousUserID, []Role{role}), nil
+}
@@ -0,0 +1,82 @@
+// audience.go
+//
+// Audience-scoped permissions for tokens shared by several backend services. A permission
+// tagged with audiences only applies when the request targets one of them and the token was
+// issued for it; untagged permissions apply to every audience. The target is the route's
+// Requirement.Audience, falling back to SERVICE_AUDIENCE, this service's own client id.
+
+package main
+
+import (
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// serviceAudience is the default target audience of this service's routes. Empty means
+// routes without an explicit Requirement.Audience accept any of the token's audiences.
+var serviceAudience string
+
+/*
+initAudienceScoping reads SERVICE_AUDIENCE.
+*/
+func initAudienceScoping() {
+	serviceAudience = os.Getenv("SERVICE_AUDIENCE")
+	if serviceAudience != "" {
+		logInfo("audience-scoped permissions enabled", "audience", serviceAudience)
+	}
+}
+
+/*
+tokenAudiences returns the token's aud claim, which may be a single string or a list.
+*/
+func tokenAudiences(claims jwt.MapClaims) []string {
+	switch aud := claims["aud"].(type) {
+	case string:
+		if aud != "" {
+			return []string{aud}
+		}
+	case []interface{}:
+		var out []string
+		for _, v := range aud {
+			if s, ok := v.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+/*
+targetAudience returns the audience a requirement is evaluated for.
+*/
+func targetAudience(req Requirement) string {
+	if req.Audience != "" {
+		return req.Audience
+	}
+	return serviceAudience
+}
+
+/*
+appliesToAudience reports whether perm takes part in a decision for the target audience.
+Untagged permissions always apply. A tagged permission applies when the target is one of its
+audiences and the token was issued for it; with no target, any audience shared with the
+token is enough. Audiences are exact client ids on both sides: "*" is not a wildcard, so a
+token issued for "*" matches nothing, and a permission meant for every audience is left
+untagged.
+*/
+func appliesToAudience(perm Permission, user *User, target string) bool {
+	if len(perm.Audiences) == 0 {
+		return true
+	}
+	if target != "" {
+		return containsExact(perm.Audiences, target) && containsExact(user.Audiences, target)
+	}
+	for _, aud := range perm.Audiences {
+		if containsExact(user.Audiences, aud) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+// audit.go
+//
+// Export of effective permissions across all roles for periodic "who can do what" audits.
+// Rows are streamed straight from the MongoDB cursor so memory use stays flat regardless
+// of how many roles exist, as a JSON array, NDJSON, or CSV.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// auditRow is one flattened (role, permission) pair with its countries already expanded.
+type auditRow struct {
+	RoleID          string   `json:"role_id"`
+	Disabled        bool     `json:"disabled"`
+	Path            string   `json:"path"`
+	Countries       []string `json:"countries"`
+	ExceptCountries []string `json:"except_countries"`
+	ExceptPaths     []string `json:"except_paths"`
+	Description     string   `json:"description,omitempty"`
+}
+
+// auditCSVHeader is the column order of the CSV export. New columns are appended so
+// existing consumers reading by position keep working.
+var auditCSVHeader = []string{"role_id", "disabled", "path", "countries", "except_countries", "except_paths", "description"}
+
+/*
+auditRows flattens a role into one row per permission, expanding regions and applying
+exclusions via effectiveCountries. Global grants are reported as "*" with the excluded
+countries listed separately.
+*/
+func auditRows(role Role) []auditRow {
+	rows := make([]auditRow, 0, len(role.Permissions))
+	for _, perm := range role.Permissions {
+		countries, except := effectiveCountries(perm)
+		rows = append(rows, auditRow{
+			RoleID:          role.RoleID,
+			Disabled:        role.Disabled,
+			Path:            perm.Path,
+			Countries:       append([]string{}, countries...),
+			ExceptCountries: append([]string{}, except...),
+			ExceptPaths:     append([]string{}, perm.ExceptPaths...),
+			Description:     perm.Description,
+		})
+	}
+	return rows
+}
+
+// Audit export formats.
+const (
+	auditFormatJSON   = "json"
+	auditFormatNDJSON = "ndjson"
+	auditFormatCSV    = "csv"
+)
+
+// mimeNDJSON is the media type of newline-delimited JSON.
+const mimeNDJSON = "application/x-ndjson"
+
+// auditCursor is the part of *mongo.Cursor the export reads, so the streaming loop can run
+// against any source of role documents.
+type auditCursor interface {
+	Next(ctx context.Context) bool
+	Decode(v interface{}) error
+	Err() error
+	Close(ctx context.Context) error
+}
+
+/*
+auditExportHandler streams every role's effective permissions. The format is chosen by
+?format= (json, ndjson, or csv) or else by the Accept header; JSON is the default.
+*/
+func auditExportHandler(c *fiber.Ctx) error {
+	format := c.Query("format")
+	if format == "" {
+		switch c.Accepts(fiber.MIMEApplicationJSON, "text/csv", mimeNDJSON) {
+		case "text/csv":
+			format = auditFormatCSV
+		case mimeNDJSON:
+			format = auditFormatNDJSON
+		default:
+			format = auditFormatJSON
+		}
+	}
+	switch format {
+	case auditFormatCSV, auditFormatNDJSON, auditFormatJSON:
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "format must be json, ndjson, or csv"})
+	}
+
+	// The query runs before the body stream starts, so a failure can still be reported as a 500.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	cursor, err := mongoDB.Collection("roles").Find(ctx, bson.M{})
+	if err != nil {
+		cancel()
+		logError("audit export query failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":  "Database query error",
+			"detail": err.Error(),
+		})
+	}
+
+	switch format {
+	case auditFormatCSV:
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="rbac-audit.csv"`)
+	case auditFormatNDJSON:
+		c.Set(fiber.HeaderContentType, mimeNDJSON)
+	default:
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	}
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		if err := streamAuditExport(ctx, cursor, w, format); err != nil {
+			logWarn("audit export aborted", "error", err)
+		}
+	})
+	return nil
+}
+
+/*
+streamAuditExport writes the rows of every role read from cursor in the given format,
+flushing after each role so memory use stays flat. A failed flush means the client went
+away: the export context is cancelled, which stops the cursor, and the cursor is always
+closed before returning, on a fresh context so the server-side cursor is released even
+after cancellation.
+*/
+func streamAuditExport(parent context.Context, cursor auditCursor, w *bufio.Writer, format string) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	defer func() {
+		closeCtx, closeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer closeCancel()
+		_ = cursor.Close(closeCtx)
+	}()
+
+	var csvWriter *csv.Writer
+	switch format {
+	case auditFormatCSV:
+		csvWriter = csv.NewWriter(w)
+		_ = csvWriter.Write(auditCSVHeader)
+	case auditFormatJSON:
+		_, _ = w.WriteString("[")
+	}
+	first := true
+	for cursor.Next(ctx) {
+		var role Role
+		if err := cursor.Decode(&role); err != nil {
+			logWarn("audit export skipped a role it could not decode", "error", err)
+			continue
+		}
+		for _, row := range auditRows(role) {
+			switch format {
+			case auditFormatCSV:
+				_ = csvWriter.Write([]string{
+					row.RoleID,
+					strconv.FormatBool(row.Disabled),
+					row.Path,
+					strings.Join(row.Countries, " "),
+					strings.Join(row.ExceptCountries, " "),
+					strings.Join(row.ExceptPaths, " "),
+					row.Description,
+				})
+			case auditFormatNDJSON:
+				data, _ := json.Marshal(row)
+				_, _ = w.Write(append(data, '\n'))
+			default:
+				if !first {
+					_, _ = w.WriteString(",")
+				}
+				first = false
+				data, _ := json.Marshal(row)
+				_, _ = w.Write(data)
+			}
+		}
+		// Flush per role so rows reach the client while the cursor is still open.
+		if csvWriter != nil {
+			csvWriter.Flush()
+		}
+		if err := w.Flush(); err != nil {
+			cancel()
+			return fmt.Errorf("client went away: %w", err)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("cursor error: %w", err)
+	}
+	switch format {
+	case auditFormatCSV:
+		csvWriter.Flush()
+	case auditFormatJSON:
+		_, _ = w.WriteString("]")
+	}
+	return w.Flush()
+}
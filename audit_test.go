@@ -0,0 +1,142 @@
+// audit_test.go
+//
+// Tests for the streaming audit export, against a fake cursor.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeCursor serves roles like a MongoDB cursor and records how it was used.
+type fakeCursor struct {
+	roles    []Role
+	next     int
+	err      error
+	closed   bool
+	closeErr error // the context error Close was called with
+	// onNext runs before each role is returned, e.g. to cancel the request.
+	onNext func(i int)
+}
+
+func (f *fakeCursor) Next(ctx context.Context) bool {
+	if f.next >= len(f.roles) {
+		return false
+	}
+	if f.onNext != nil {
+		f.onNext(f.next)
+	}
+	if err := ctx.Err(); err != nil {
+		f.err = err
+		return false
+	}
+	f.next++
+	return true
+}
+
+func (f *fakeCursor) Decode(v interface{}) error {
+	*v.(*Role) = f.roles[f.next-1]
+	return nil
+}
+
+func (f *fakeCursor) Err() error { return f.err }
+
+func (f *fakeCursor) Close(ctx context.Context) error {
+	f.closed, f.closeErr = true, ctx.Err()
+	return nil
+}
+
+// failingWriter accepts limit bytes, then fails like a connection the client closed.
+type failingWriter struct {
+	limit int
+	buf   bytes.Buffer
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.limit {
+		return 0, errors.New("broken pipe")
+	}
+	return w.buf.Write(p)
+}
+
+func auditTestRoles(n int) []Role {
+	roles := make([]Role, n)
+	for i := range roles {
+		roles[i] = Role{RoleID: fmt.Sprintf("role-%d", i), Permissions: []Permission{
+			{Path: "hr:profile:view", Countries: []string{"TH"}},
+		}}
+	}
+	return roles
+}
+
+func TestStreamAuditExportClientGoesAway(t *testing.T) {
+	cursor := &fakeCursor{roles: auditTestRoles(100)}
+	out := &failingWriter{limit: 300}
+	err := streamAuditExport(context.Background(), cursor, bufio.NewWriterSize(out, 16), auditFormatNDJSON)
+	if err == nil || !strings.Contains(err.Error(), "client went away") {
+		t.Fatalf("streamAuditExport error = %v, want client went away", err)
+	}
+	if !cursor.closed || cursor.closeErr != nil {
+		t.Fatalf("cursor closed = %v with context error %v, want closed on a live context", cursor.closed, cursor.closeErr)
+	}
+	if cursor.next == len(cursor.roles) {
+		t.Fatal("export kept reading the cursor after the client went away")
+	}
+}
+
+func TestStreamAuditExportRequestCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cursor := &fakeCursor{roles: auditTestRoles(10), onNext: func(i int) {
+		if i == 3 {
+			cancel()
+		}
+	}}
+	var out bytes.Buffer
+	err := streamAuditExport(ctx, cursor, bufio.NewWriter(&out), auditFormatNDJSON)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("streamAuditExport error = %v, want context.Canceled", err)
+	}
+	if !cursor.closed || cursor.closeErr != nil {
+		t.Fatalf("cursor closed = %v with context error %v, want closed on a fresh context", cursor.closed, cursor.closeErr)
+	}
+	// The three roles read before the cancellation were already flushed.
+	if lines := strings.Count(out.String(), "\n"); lines != 3 {
+		t.Fatalf("streamed %d rows before cancellation, want 3", lines)
+	}
+}
+
+func TestStreamAuditExportFormats(t *testing.T) {
+	for _, format := range []string{auditFormatJSON, auditFormatNDJSON, auditFormatCSV} {
+		cursor := &fakeCursor{roles: auditTestRoles(2)}
+		var out bytes.Buffer
+		if err := streamAuditExport(context.Background(), cursor, bufio.NewWriter(&out), format); err != nil {
+			t.Fatalf("%s: streamAuditExport: %v", format, err)
+		}
+		if !cursor.closed {
+			t.Errorf("%s: cursor not closed", format)
+		}
+		switch format {
+		case auditFormatJSON:
+			var rows []map[string]interface{}
+			if err := json.Unmarshal(out.Bytes(), &rows); err != nil || len(rows) != 2 {
+				t.Errorf("json: %d rows, %v; want a 2-element array", len(rows), err)
+			}
+		case auditFormatNDJSON:
+			if lines := strings.Count(out.String(), "\n"); lines != 2 {
+				t.Errorf("ndjson: %d lines, want 2", lines)
+			}
+		case auditFormatCSV:
+			if lines := strings.Count(out.String(), "\n"); lines != 3 {
+				t.Errorf("csv: %d lines, want a header and 2 rows", lines)
+			}
+		}
+	}
+}
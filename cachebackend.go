@@ -0,0 +1,193 @@
+// cachebackend.go
+//
+// The backend behind the role and decision caches. CACHE_BACKEND=memory (the default) keeps
+// both caches in process, so an edit only invalidates the replica that served it. With
+// CACHE_BACKEND=redis each replica still caches locally, but invalidations are published on
+// a Redis pub/sub channel and applied by every replica, so all pods drop an edited role
+// and the decisions computed from it together.
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// cacheBackend is what loadRole and the role editors use to reach the caches. Backends
+// decide how far an invalidation travels; the RBAC core only calls these methods.
+type cacheBackend interface {
+	// get returns the cached role for roleID, if any.
+	get(roleID string) (Role, bool)
+	// set caches a role loaded from MongoDB.
+	set(roleID string, role Role)
+	// invalidate drops roleID and every cached decision wherever the backend reaches.
+	invalidate(roleID string)
+	// clear drops every cached role and decision of this replica.
+	clear()
+}
+
+// roleCaches is the active cache backend. It works before initCacheBackend runs and
+// when caching is disabled, by caching nothing.
+var roleCaches cacheBackend = memoryBackend{}
+
+/*
+initCacheBackend selects the cache backend from CACHE_BACKEND (memory or redis). It runs
+after initRoleCache and initDecisionCache, whose local caches both backends use.
+*/
+func initCacheBackend() {
+	local := memoryBackend{roles: rolesCache}
+	switch v := os.Getenv("CACHE_BACKEND"); v {
+	case "", "memory":
+		roleCaches = local
+	case "redis":
+		roleCaches = newRedisBackend(local)
+	default:
+		log.Fatalf("Invalid CACHE_BACKEND %q: expected memory or redis", v)
+	}
+}
+
+/*
+cacheBackendName returns the CACHE_BACKEND in use, for health and debug output.
+*/
+func cacheBackendName() string {
+	if _, ok := roleCaches.(*redisBackend); ok {
+		return "redis"
+	}
+	return "memory"
+}
+
+// memoryBackend is the in-process backend. roles is nil when ROLE_CACHE_TTL is not set, in
+// which case only decisions are cached.
+type memoryBackend struct {
+	roles *roleCache
+}
+
+func (m memoryBackend) get(roleID string) (Role, bool) {
+	if m.roles == nil {
+		return Role{}, false
+	}
+	return m.roles.get(roleID)
+}
+
+func (m memoryBackend) set(roleID string, role Role) {
+	if m.roles != nil {
+		m.roles.set(roleID, role)
+	}
+}
+
+func (m memoryBackend) invalidate(roleID string) {
+	if m.roles != nil {
+		m.roles.invalidate(roleID)
+	}
+	// Decisions are cached even when roles are not, so drop them either way.
+	invalidateDecisions()
+}
+
+func (m memoryBackend) clear() {
+	if m.roles != nil {
+		m.roles.clear()
+	}
+	invalidateDecisions()
+}
+
+// invalidateAllRoles is the invalidation message that drops every role.
+const invalidateAllRoles = "*"
+
+// redisBackend caches in process like memoryBackend and shares invalidations through a
+// Redis channel. Messages are role ids, or invalidateAllRoles.
+type redisBackend struct {
+	memoryBackend
+	client  *redis.Client
+	channel string
+
+	published atomic.Uint64
+	received  atomic.Uint64
+	failed    atomic.Uint64
+}
+
+/*
+newRedisBackend connects to REDIS_URL (e.g. redis://redis:6379/0) and subscribes to
+CACHE_INVALIDATION_CHANNEL (default "rbac:cache:invalidate"). An unreachable Redis stops
+the service, since replicas would otherwise silently serve stale roles.
+*/
+func newRedisBackend(local memoryBackend) *redisBackend {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		log.Fatal("CACHE_BACKEND=redis requires REDIS_URL")
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		log.Fatalf("Invalid REDIS_URL: %v", err)
+	}
+	channel := os.Getenv("CACHE_INVALIDATION_CHANNEL")
+	if channel == "" {
+		channel = "rbac:cache:invalidate"
+	}
+	rb := &redisBackend{memoryBackend: local, client: redis.NewClient(opts), channel: channel}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pubsub := rb.client.Subscribe(ctx, channel)
+	// The first reply confirms the subscription, so a bad address fails here.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		log.Fatalf("Failed to subscribe to Redis channel %s: %v", channel, err)
+	}
+	go rb.listen(pubsub)
+
+	registerCounter("rbac_cache_invalidations_published_total", "Cache invalidations published to Redis.", rb.published.Load)
+	registerCounter("rbac_cache_invalidations_received_total", "Cache invalidations received from Redis.", rb.received.Load)
+	registerCounter("rbac_cache_invalidations_failed_total", "Cache invalidations that could not be published to Redis.", rb.failed.Load)
+	logInfo("cache invalidations shared through Redis", "channel", channel)
+	return rb
+}
+
+/*
+invalidate drops the role locally right away, so the editing replica never serves the old
+definition, then publishes it for the other replicas.
+*/
+func (rb *redisBackend) invalidate(roleID string) {
+	rb.memoryBackend.invalidate(roleID)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := rb.client.Publish(ctx, rb.channel, roleID).Err(); err != nil {
+		rb.failed.Add(1)
+		logWarn("cache invalidation publish failed", "role", roleID, "error", err)
+		return
+	}
+	rb.published.Add(1)
+}
+
+/*
+listen applies invalidations published by any replica, including this one. The client
+resubscribes after a lost connection; messages sent meanwhile are gone, so every
+resubscription clears the local caches.
+*/
+func (rb *redisBackend) listen(pubsub *redis.PubSub) {
+	for {
+		msg, err := pubsub.Receive(context.Background())
+		if err != nil {
+			logWarn("cache invalidation subscription failed", "channel", rb.channel, "error", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind == "subscribe" {
+				logInfo("resubscribed to cache invalidations, clearing local caches", "channel", rb.channel)
+				rb.memoryBackend.clear()
+			}
+		case *redis.Message:
+			rb.received.Add(1)
+			if msg.Payload == invalidateAllRoles {
+				rb.memoryBackend.clear()
+			} else {
+				rb.memoryBackend.invalidate(msg.Payload)
+			}
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// capabilities.go
+//
+// Introspection endpoints describing what the authenticated caller is allowed to do,
+// so front-ends can gate UI elements without probing every protected route.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Capability is a single effective grant for the caller, with regions already expanded.
+type Capability struct {
+	RoleID          string      `json:"role_id"`
+	RoleDescription string      `json:"role_description,omitempty"`
+	Path            string      `json:"path"`
+	Description     string      `json:"description,omitempty"`
+	Countries       []string    `json:"countries"`
+	ExceptCountries []string    `json:"except_countries,omitempty"`
+	ExceptPaths     []string    `json:"except_paths,omitempty"`
+	Priority        int         `json:"priority,omitempty"`
+	Conditions      []Condition `json:"conditions,omitempty"`
+	Audiences       []string    `json:"audiences,omitempty"`
+}
+
+/*
+userCapabilities flattens the user's roles into a deterministic, sorted list of capabilities.
+The ordering is stable so the result can be hashed into an ETag.
+*/
+func userCapabilities(user *User) []Capability {
+	caps := []Capability{}
+	for _, role := range user.Roles {
+		for _, perm := range role.Permissions {
+			countries, except := effectiveCountries(perm)
+			exceptPaths := append([]string(nil), perm.ExceptPaths...)
+			sort.Strings(exceptPaths)
+			caps = append(caps, Capability{
+				RoleID:          role.RoleID,
+				RoleDescription: role.Description,
+				Path:            perm.Path,
+				Description:     perm.Description,
+				Countries:       countries,
+				ExceptCountries: except,
+				ExceptPaths:     exceptPaths,
+				Priority:        perm.Priority,
+				Conditions:      perm.Conditions,
+				Audiences:       perm.Audiences,
+			})
+		}
+	}
+	sort.SliceStable(caps, func(i, j int) bool {
+		if caps[i].Path != caps[j].Path {
+			return caps[i].Path < caps[j].Path
+		}
+		return caps[i].RoleID < caps[j].RoleID
+	})
+	return caps
+}
+
+/*
+capabilitiesETag derives a strong ETag from the serialized capabilities. Because capabilities
+are computed from the currently loaded role definitions, the ETag changes as soon as an
+updated role is served by MongoDB or the role cache.
+*/
+func capabilitiesETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+/*
+etagMatches reports whether an If-None-Match header value matches the given ETag,
+accepting "*", comma-separated lists, and weak validators.
+*/
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+filterCapabilities keeps only the capabilities whose path intersects pattern.
+*/
+func filterCapabilities(caps []Capability, pattern string) []Capability {
+	filtered := []Capability{}
+	for _, capability := range caps {
+		if pathsOverlap(capability.Path, pattern) {
+			filtered = append(filtered, capability)
+		}
+	}
+	return filtered
+}
+
+/*
+filterPermissions keeps only the permissions whose path intersects pattern.
+*/
+func filterPermissions(perms []Permission, pattern string) []Permission {
+	var filtered []Permission
+	for _, perm := range perms {
+		if pathsOverlap(perm.Path, pattern) {
+			filtered = append(filtered, perm)
+		}
+	}
+	return filtered
+}
+
+/*
+capabilitiesHandler returns the caller's effective capabilities. An optional ?path= pattern
+(e.g. "hr:*") limits the result to grants that intersect it, and ?merged=true adds the
+role-agnostic MergeEffectivePermissions view. Clients that send a matching If-None-Match
+header receive 304 Not Modified with no body.
+*/
+func capabilitiesHandler(c *fiber.Ctx) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	caps := userCapabilities(user)
+	var merged []Permission
+	if c.QueryBool("merged") {
+		merged = MergeEffectivePermissions(user)
+	}
+	if pattern := c.Query("path"); pattern != "" {
+		if err := validateRequirement(Requirement{Path: pattern, Country: "GLOBAL"}); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid path pattern: " + err.Error()})
+		}
+		caps = filterCapabilities(caps, pattern)
+		merged = filterPermissions(merged, pattern)
+	}
+
+	countries := append([]string(nil), user.AllowedCountries...)
+	sort.Strings(countries)
+	body, err := encodeResponse(CapabilitiesResponse{
+		User:                 user.ID,
+		AllowedCountries:     countries,
+		Capabilities:         caps,
+		EffectivePermissions: merged,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to encode capabilities"})
+	}
+
+	etag := capabilitiesETag(body)
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderCacheControl, "private, no-cache")
+	if inm := c.Get(fiber.HeaderIfNoneMatch); inm != "" && etagMatches(inm, etag) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(body)
+}
+
+// maxBulkCheckPaths caps the paths of one POST /rbac/bulk-check (BULK_CHECK_MAX_PATHS).
+var maxBulkCheckPaths = 200
+
+/*
+initBulkCheck reads BULK_CHECK_MAX_PATHS.
+*/
+func initBulkCheck() {
+	if v := os.Getenv("BULK_CHECK_MAX_PATHS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid BULK_CHECK_MAX_PATHS %q: must be a positive integer", v)
+		}
+		maxBulkCheckPaths = n
+	}
+}
+
+// bulkCheckRequest is the body of POST /rbac/bulk-check.
+type bulkCheckRequest struct {
+	Country string   `json:"country"`
+	Paths   []string `json:"paths"`
+}
+
+/*
+bulkCheckHandler evaluates every path in the body for the caller in one country and returns a
+path -> allowed map, so menus can be rendered in a single round trip. The user is resolved
+once by the middleware; request attributes are not known here, so conditional grants deny.
+*/
+func bulkCheckHandler(c *fiber.Ctx) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	var body bulkCheckRequest
+	if err := decodeStrictJSON(c.Body(), &body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Invalid bulk-check body",
+			"detail": err.Error(),
+		})
+	}
+	if body.Country == "" || len(body.Paths) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "country and paths are required"})
+	}
+	if len(body.Paths) > maxBulkCheckPaths {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("at most %d paths may be checked per request", maxBulkCheckPaths),
+		})
+	}
+	country := normalizeCountry(body.Country)
+	if country != "GLOBAL" && !isKnownCountry(country) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "country must be GLOBAL or a known ISO-2 code"})
+	}
+	results := make(map[string]bool, len(body.Paths))
+	for _, path := range body.Paths {
+		req := Requirement{Path: path, Country: country}
+		if err := validateRequirement(req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid path", "detail": err.Error()})
+		}
+		results[path] = IsAllowed(user, req)
+	}
+	return c.JSON(results)
+}
@@ -0,0 +1,429 @@
+// claims.go
+//
+// Extraction of role identifiers from JWT claims. Different identity providers encode
+// roles differently, so the claim shapes accepted here are configurable via environment.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	// rolesObjectField is the key read from each roles element when the element is an
+	// object, e.g. "authority" for [{"authority":"ROLE_VIEWER"}]. Empty disables object roles.
+	rolesObjectField string
+	// rolesPrefix is stripped from every role name, e.g. "ROLE_", to map IdP roles to role_ids.
+	rolesPrefix string
+	// countryClaim is the claim holding the user's home country, used by CountryFromToken.
+	countryClaim = "country"
+	// scopeClaim, when set, is an OAuth-style space- or comma-separated claim (e.g. "scope")
+	// whose entries are treated as additional role ids.
+	scopeClaim string
+	// roleExpiryClaim is an object claim mapping role ids to the time their assignment
+	// expires, as unix seconds or RFC 3339. Empty disables role expiry.
+	roleExpiryClaim = "role_expiry"
+	// allowedCountriesClaim, when set, is an array claim of ISO-2 codes combined with the
+	// role-derived countries (JWT_ALLOWED_COUNTRIES_CLAIM).
+	allowedCountriesClaim string
+	// allowedCountriesUnion adds the claim's countries instead of intersecting with them
+	// (JWT_ALLOWED_COUNTRIES_MODE=union).
+	allowedCountriesUnion bool
+	// rolesClaimMissingEmpty treats a token without any role source as a user with no roles
+	// instead of rejecting it (ROLES_CLAIM_MISSING=empty).
+	rolesClaimMissingEmpty bool
+)
+
+/*
+initClaimsConfig loads the role-claim extraction settings from environment variables.
+*/
+func initClaimsConfig() {
+	rolesObjectField = os.Getenv("JWT_ROLES_OBJECT_FIELD")
+	rolesPrefix = os.Getenv("JWT_ROLES_PREFIX")
+	if v := os.Getenv("JWT_COUNTRY_CLAIM"); v != "" {
+		countryClaim = v
+	}
+	scopeClaim = os.Getenv("JWT_SCOPE_CLAIM")
+	if v, ok := os.LookupEnv("JWT_ROLE_EXPIRY_CLAIM"); ok {
+		roleExpiryClaim = v
+	}
+	if scopeClaim != "" {
+		logInfo("reading additional roles from scope claim", "claim", scopeClaim)
+	}
+	if rolesObjectField != "" {
+		logInfo("reading object roles", "field", rolesObjectField)
+	}
+	if rolesPrefix != "" {
+		logInfo("stripping role prefix", "prefix", rolesPrefix)
+	}
+	allowedCountriesClaim = os.Getenv("JWT_ALLOWED_COUNTRIES_CLAIM")
+	switch v := os.Getenv("JWT_ALLOWED_COUNTRIES_MODE"); v {
+	case "", "intersect":
+	case "union":
+		allowedCountriesUnion = true
+	default:
+		log.Fatalf("Invalid JWT_ALLOWED_COUNTRIES_MODE %q: expected intersect or union", v)
+	}
+	if allowedCountriesClaim != "" {
+		mode := "intersect"
+		if allowedCountriesUnion {
+			mode = "union"
+		}
+		logInfo("combining allowed countries from claim", "claim", allowedCountriesClaim, "mode", mode)
+	}
+	switch v := os.Getenv("ROLES_CLAIM_MISSING"); v {
+	case "", "error":
+	case "empty":
+		rolesClaimMissingEmpty = true
+		logInfo("tokens without a roles claim are treated as users with no roles")
+	default:
+		log.Fatalf("Invalid ROLES_CLAIM_MISSING %q: expected error or empty", v)
+	}
+}
+
+// roleRequiredCode is the denial code of a caller lacking one of Requirement.RequiredRoles.
+const roleRequiredCode = "ROLE_REQUIRED"
+
+/*
+missingRequiredRoles returns the required role ids absent from the token's roles, read the
+same way extractUser reads them, so an expired role assignment is missing too. A token whose
+roles cannot be read lacks every role.
+*/
+func missingRequiredRoles(claims jwt.MapClaims, required []string) []string {
+	if len(required) == 0 {
+		return nil
+	}
+	roleIDs, _ := extractRoleIDs(claims)
+	roleIDs, _ = unexpiredRoleIDs(claims, roleIDs)
+	var missing []string
+	for _, role := range required {
+		if !containsExact(roleIDs, role) {
+			missing = append(missing, role)
+		}
+	}
+	return missing
+}
+
+/*
+roleIDFromClaim converts a single element of the roles claim into a role id. Plain strings are
+used as-is; objects are accepted only when JWT_ROLES_OBJECT_FIELD is configured. The configured
+prefix is stripped in both cases.
+*/
+func roleIDFromClaim(v interface{}) (string, bool) {
+	var id string
+	switch r := v.(type) {
+	case string:
+		id = r
+	case map[string]interface{}:
+		if rolesObjectField == "" {
+			return "", false
+		}
+		s, ok := r[rolesObjectField].(string)
+		if !ok {
+			return "", false
+		}
+		id = s
+	default:
+		return "", false
+	}
+	if rolesPrefix != "" {
+		id = strings.TrimPrefix(id, rolesPrefix)
+	}
+	return id, id != ""
+}
+
+/*
+scopeRoleIDs splits the configured scope claim on spaces and commas into role ids.
+*/
+func scopeRoleIDs(claims jwt.MapClaims) ([]string, bool) {
+	if scopeClaim == "" {
+		return nil, false
+	}
+	scope, ok := claims[scopeClaim].(string)
+	if !ok {
+		return nil, false
+	}
+	return strings.FieldsFunc(scope, func(r rune) bool {
+		return r == ' ' || r == ','
+	}), true
+}
+
+/*
+extractRoleIDs reads the roles claim, merged with the scope claim when JWT_SCOPE_CLAIM is
+set and with the roles mapped from the groups claim when a group mapping is configured, and
+returns the deduplicated list of role ids, dropping elements that cannot be interpreted as
+a role. A token must carry at least one of these claims; under ROLES_CLAIM_MISSING=empty a
+token carrying none yields no roles instead. A roles claim that is present but not an array
+is always an error.
+*/
+func extractRoleIDs(claims jwt.MapClaims) ([]string, error) {
+	rawRoles, present := claims["roles"]
+	rolesIface, hasRoles := rawRoles.([]interface{})
+	scopes, hasScope := scopeRoleIDs(claims)
+	groupIDs, hasGroups := groupRoleIDs(claims)
+	if !hasRoles && !hasScope && !hasGroups {
+		if present {
+			return nil, fmt.Errorf("roles claim in wrong format: expected an array")
+		}
+		if rolesClaimMissingEmpty {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("roles claim missing")
+	}
+	candidates := append([]interface{}{}, rolesIface...)
+	for _, s := range scopes {
+		candidates = append(candidates, s)
+	}
+
+	// Deduplicate role ids so a repeated role is only loaded and counted once.
+	var roleIDs []string
+	seenRoles := make(map[string]struct{})
+	for _, r := range candidates {
+		id, ok := roleIDFromClaim(r)
+		if !ok {
+			continue
+		}
+		if _, dup := seenRoles[id]; dup {
+			continue
+		}
+		seenRoles[id] = struct{}{}
+		roleIDs = append(roleIDs, id)
+	}
+	// Mapped ids are role_ids already, so the roles prefix is not applied to them.
+	for _, id := range groupIDs {
+		if _, dup := seenRoles[id]; dup || id == "" {
+			continue
+		}
+		seenRoles[id] = struct{}{}
+		roleIDs = append(roleIDs, id)
+	}
+	return roleIDs, nil
+}
+
+/*
+unexpiredRoleIDs splits roleIDs into the roles still assigned and those whose entry in the
+role expiry claim lies in the past. Expiry keys are role names as in the roles claim, with
+the configured prefix stripped. An entry that is not a timestamp counts as expired.
+*/
+func unexpiredRoleIDs(claims jwt.MapClaims, roleIDs []string) (active, expired []string) {
+	expiries, _ := claims[roleExpiryClaim].(map[string]interface{})
+	if roleExpiryClaim == "" || len(expiries) == 0 {
+		return roleIDs, nil
+	}
+	deadlines := make(map[string]interface{}, len(expiries))
+	for name, v := range expiries {
+		deadlines[strings.TrimPrefix(name, rolesPrefix)] = v
+	}
+	now := time.Now()
+	for _, id := range roleIDs {
+		v, ok := deadlines[id]
+		if !ok {
+			active = append(active, id)
+			continue
+		}
+		if at, ok := expiryTime(v); ok && now.Before(at) {
+			active = append(active, id)
+			continue
+		}
+		expired = append(expired, id)
+	}
+	return active, expired
+}
+
+/*
+expiryTime converts a role expiry claim value, unix seconds or an RFC 3339 string, to a time.
+*/
+func expiryTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case float64:
+		return time.Unix(int64(t), 0), true
+	case string:
+		if at, err := time.Parse(time.RFC3339, t); err == nil {
+			return at, true
+		}
+		if secs, err := strconv.ParseInt(t, 10, 64); err == nil {
+			return time.Unix(secs, 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+/*
+tokenCountry reads the caller's home country from the token, uppercased, and validates
+that it is a known ISO-2 code.
+*/
+func tokenCountry(claims jwt.MapClaims) (string, error) {
+	country, ok := claims[countryClaim].(string)
+	if !ok || country == "" {
+		return "", fmt.Errorf("%s claim missing or not a string in token", countryClaim)
+	}
+	country = normalizeCountry(country)
+	if !isKnownCountry(country) {
+		return "", fmt.Errorf("%s claim %q is not a known country code", countryClaim, country)
+	}
+	return country, nil
+}
+
+/*
+checkTokenFreshness enforces a Requirement's MaxTokenAge. The caller's authentication time is
+the auth_time claim, which survives token refreshes, or iat when auth_time is absent. A token
+carrying neither cannot prove it is fresh and is rejected. Timestamps in the future (clock
+skew) count as age zero. A zero maxAge disables the check.
+*/
+func checkTokenFreshness(claims jwt.MapClaims, maxAge time.Duration) error {
+	if maxAge <= 0 {
+		return nil
+	}
+	name := "auth_time"
+	v, ok := claims[name]
+	if !ok {
+		name = "iat"
+		v, ok = claims[name]
+	}
+	if !ok {
+		return fmt.Errorf("token has no auth_time or iat claim to check its age")
+	}
+	at, ok := numericTime(v)
+	if !ok {
+		return fmt.Errorf("%s claim is not a numeric timestamp", name)
+	}
+	if age := time.Since(at); age > maxAge {
+		return fmt.Errorf("token %s is %s old, exceeding %s", name, age.Truncate(time.Second), maxAge)
+	}
+	return nil
+}
+
+/*
+numericTime converts a NumericDate claim value (unix seconds, possibly fractional) to a time.
+Claims decode as float64, or as json.Number when the parser is configured with UseNumber.
+*/
+func numericTime(v interface{}) (time.Time, bool) {
+	var secs float64
+	switch t := v.(type) {
+	case float64:
+		secs = t
+	case json.Number:
+		f, err := t.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		secs = f
+	case int64:
+		secs = float64(t)
+	default:
+		return time.Time{}, false
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(frac*1e9)), true
+}
+
+/*
+meetsAuthLevel checks the token's acr and amr claims against the Requirement's step-up
+settings. When both MinAcr and the token's acr are numeric (Keycloak's "0", "1", "2"),
+the token level must be at least MinAcr; otherwise they must be equal. Every RequiredAmr
+method must appear in the token's amr array.
+*/
+func meetsAuthLevel(claims jwt.MapClaims, req Requirement) bool {
+	if req.MinAcr != "" {
+		acr, _ := claims["acr"].(string)
+		want, wantErr := strconv.Atoi(req.MinAcr)
+		got, gotErr := strconv.Atoi(acr)
+		if wantErr == nil && gotErr == nil {
+			if got < want {
+				return false
+			}
+		} else if acr != req.MinAcr {
+			return false
+		}
+	}
+	if len(req.RequiredAmr) > 0 {
+		amrIface, _ := claims["amr"].([]interface{})
+		methods := make(map[string]struct{}, len(amrIface))
+		for _, m := range amrIface {
+			if s, ok := m.(string); ok {
+				methods[s] = struct{}{}
+			}
+		}
+		for _, required := range req.RequiredAmr {
+			if _, ok := methods[required]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/*
+applyCountriesClaim combines the allowed countries claim with the user's role-derived
+countries. In intersect mode (the default) the claim can only narrow the set, which is
+logged; a global role grant is narrowed to exactly the claimed countries. In union mode the
+claimed countries are added and, like user override additions, extend the country scope of
+every grant that does not exclude them. A token without the claim is left as is. Codes must
+be known ISO-2 countries.
+*/
+func applyCountriesClaim(user *User, claims jwt.MapClaims) error {
+	if allowedCountriesClaim == "" {
+		return nil
+	}
+	raw, present := claims[allowedCountriesClaim]
+	if !present {
+		return nil
+	}
+	values, ok := raw.([]interface{})
+	if !ok {
+		return fmt.Errorf("%s claim in wrong format: expected an array", allowedCountriesClaim)
+	}
+	claimed := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		code, ok := v.(string)
+		if code = normalizeCountry(code); !ok || !isKnownCountry(code) {
+			return fmt.Errorf("%s claim: %q is not a known country code", allowedCountriesClaim, v)
+		}
+		claimed[code] = struct{}{}
+	}
+
+	if allowedCountriesUnion {
+		set := make(map[string]struct{}, len(user.AllowedCountries)+len(claimed))
+		for _, c := range user.AllowedCountries {
+			set[c] = struct{}{}
+		}
+		for c := range claimed {
+			set[c] = struct{}{}
+			if !containsExact(user.addedCountries, c) {
+				user.addedCountries = append(user.addedCountries, c)
+			}
+		}
+		user.AllowedCountries = countryList(set)
+		return nil
+	}
+
+	before := len(user.AllowedCountries)
+	global := before == 1 && user.AllowedCountries[0] == "*"
+	var narrowed []string
+	for c := range claimed {
+		if global || containsExact(user.AllowedCountries, c) {
+			narrowed = append(narrowed, c)
+		}
+	}
+	sort.Strings(narrowed)
+	if global || len(narrowed) < before {
+		roleCountries := strconv.Itoa(before)
+		if global {
+			roleCountries = "*"
+		}
+		logInfo("countries claim narrowed allowed countries", "user", logSafe(user.ID),
+			"role_countries", roleCountries, "allowed", narrowed)
+	}
+	user.AllowedCountries = narrowed
+	return nil
+}
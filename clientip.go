@@ -0,0 +1,106 @@
+// clientip.go
+//
+// Client IP resolution behind trusted proxies. X-Forwarded-For is only honored when the
+// direct peer is listed in TRUSTED_PROXIES; otherwise the socket address is used, so a
+// client talking to the service directly cannot spoof its IP with a forged header. The
+// resolved IP is stored in Locals("client_ip") for audit logs, hooks, and rate limiting.
+
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// trustedProxies are the networks whose X-Forwarded-For entries are believed.
+var trustedProxies []*net.IPNet
+
+/*
+initTrustedProxies parses TRUSTED_PROXIES, a comma-separated list of IPs or CIDR ranges
+(e.g. "10.0.0.0/8,172.18.0.5"). An empty list trusts no proxy.
+*/
+func initTrustedProxies() {
+	raw := os.Getenv("TRUSTED_PROXIES")
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Fatalf("Invalid TRUSTED_PROXIES entry %q: %v", entry, err)
+		}
+		trustedProxies = append(trustedProxies, network)
+	}
+	if len(trustedProxies) > 0 {
+		logInfo("trusted proxies", "proxies", raw)
+	}
+}
+
+/*
+isTrustedProxy reports whether ip belongs to one of the trusted proxy networks.
+*/
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+resolveClientIP returns the client IP for a request from peer with the given
+X-Forwarded-For header. Starting from the direct peer, it walks the header right to left
+while the current hop is a trusted proxy, and stops at the first untrusted address: that
+is the last hop no trusted proxy can vouch beyond. Malformed entries end the walk.
+*/
+func resolveClientIP(peer string, forwardedFor string) string {
+	ip := net.ParseIP(peer)
+	if ip == nil || !isTrustedProxy(ip) || forwardedFor == "" {
+		return peer
+	}
+	hops := strings.Split(forwardedFor, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+/*
+clientIPMiddleware stores the resolved client IP in Locals("client_ip").
+*/
+func clientIPMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("client_ip", resolveClientIP(c.Context().RemoteIP().String(), c.Get(fiber.HeaderXForwardedFor)))
+		return c.Next()
+	}
+}
+
+/*
+clientIP returns the IP resolved by clientIPMiddleware, falling back to the socket address.
+*/
+func clientIP(c *fiber.Ctx) string {
+	if ip, ok := c.Locals("client_ip").(string); ok {
+		return ip
+	}
+	return c.IP()
+}
@@ -0,0 +1,107 @@
+// composite.go
+//
+// Composite route guards combining several Requirements with AND (requireAll) or OR
+// (requireAny). Every Requirement is evaluated, without short-circuiting, so a denial can
+// tell the client exactly which checks passed and which are missing.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// compositeMode is how a composite guard combines its Requirements.
+type compositeMode string
+
+const (
+	compositeAll compositeMode = "all"
+	compositeAny compositeMode = "any"
+)
+
+// requirementResult is one entry of a composite denial's breakdown. Code is set for missing
+// checks: PERMISSION_DENIED, ROLE_REQUIRED, STEP_UP_REQUIRED, REAUTH_REQUIRED,
+// FEATURE_DISABLED, or LEGALLY_RESTRICTED. A feature hidden with a 404 is reported as PERMISSION_DENIED.
+type requirementResult struct {
+	Path    string `json:"path"`
+	Country string `json:"country"`
+	Code    string `json:"code,omitempty"`
+}
+
+/*
+requireAll returns a middleware that allows the request only when every Requirement is met.
+*/
+func requireAll(reqs ...Requirement) fiber.Handler {
+	return requireComposite(compositeAll, reqs)
+}
+
+/*
+requireAny returns a middleware that allows the request when at least one Requirement is met.
+*/
+func requireAny(reqs ...Requirement) fiber.Handler {
+	return requireComposite(compositeAny, reqs)
+}
+
+/*
+requireComposite evaluates every Requirement for the caller and combines the results. A
+denial is 403 with code REQUIREMENTS_NOT_MET and the requirements split into "satisfied"
+and "missing". On success the user is stored in Locals, and "decision" holds the decision of
+the first satisfied Requirement. A malformed route parameter still fails fast with 400.
+*/
+func requireComposite(mode compositeMode, reqs []Requirement) fiber.Handler {
+	if len(reqs) == 0 {
+		log.Fatalf("Composite route requirement (%s) has no Requirements", mode)
+	}
+	for _, req := range reqs {
+		if err := validateRequirement(req); err != nil {
+			log.Fatalf("Invalid route requirement %+v: %v", req, err)
+		}
+	}
+	return func(c *fiber.Ctx) error {
+		claims, user, status, err := resolveCaller(c)
+		if err != nil {
+			if status == fiber.StatusForbidden {
+				logDenial(c, claims, reqs[0], err.Error())
+			}
+			return denyWithCode(c, status, userErrorCode(err), err.Error())
+		}
+
+		satisfied := []requirementResult{}
+		missing := []requirementResult{}
+		var granted *requirementCheck
+		var reasons []string
+		for _, req := range reqs {
+			check := evaluateRequirement(c, claims, user, req)
+			if check.status == fiber.StatusBadRequest {
+				return deny(c, check.status, check.message)
+			}
+			result := requirementResult{Path: check.target.Path, Country: check.target.Country}
+			if check.satisfied() {
+				satisfied = append(satisfied, result)
+				if granted == nil {
+					granted = &check
+				}
+				continue
+			}
+			result.Code = check.code
+			if result.Code == "" {
+				result.Code = "PERMISSION_DENIED"
+			}
+			missing = append(missing, result)
+			reasons = append(reasons, check.target.Path+": "+check.reason)
+		}
+
+		if granted != nil && (mode == compositeAny || len(missing) == 0) {
+			c.Locals("user", user)
+			c.Locals("decision", granted.decision)
+			return c.Next()
+		}
+		logDenial(c, claims, reqs[0], fmt.Sprintf("requirements not met (%s): %s", mode, strings.Join(reasons, "; ")))
+		return denyWithDetails(c, fiber.StatusForbidden, "REQUIREMENTS_NOT_MET",
+			"Access denied. You do not meet the requirements for this resource.",
+			fiber.Map{"mode": mode, "satisfied": satisfied, "missing": missing})
+	}
+}
@@ -0,0 +1,51 @@
+// compression.go
+//
+// Optional gzip/deflate/brotli compression of responses for clients that send
+// Accept-Encoding. Large role sets make /user/profile and /rbac/capabilities heavy,
+// while health and metrics endpoints are left uncompressed for simple probes and scrapers.
+
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/compress"
+)
+
+// uncompressedPaths are never compressed so probes and scrapers get plain responses.
+var uncompressedPaths = map[string]struct{}{
+	"/healthz": {},
+	"/metrics": {},
+}
+
+/*
+compressionMiddleware returns the compress middleware configured by COMPRESS_LEVEL
+(disabled, default, speed, or best), or nil when compression is disabled.
+*/
+func compressionMiddleware() fiber.Handler {
+	var level compress.Level
+	switch v := os.Getenv("COMPRESS_LEVEL"); v {
+	case "", "disabled":
+		return nil
+	case "default":
+		level = compress.LevelDefault
+	case "speed":
+		level = compress.LevelBestSpeed
+	case "best":
+		level = compress.LevelBestCompression
+	default:
+		log.Fatalf("Invalid COMPRESS_LEVEL %q: expected disabled, default, speed, or best", v)
+	}
+	logInfo("response compression enabled", "level", os.Getenv("COMPRESS_LEVEL"))
+	return compress.New(compress.Config{
+		Level: level,
+		Next: func(c *fiber.Ctx) bool {
+			if _, skip := uncompressedPaths[c.Path()]; skip {
+				return true
+			}
+			return isWebSocketUpgrade(c)
+		},
+	})
+}
@@ -0,0 +1,141 @@
+// conditions.go
+//
+// Lightweight attribute conditions (ABAC) layered on top of RBAC. A permission may carry
+// conditions such as {"field":"amount","operator":"lt","value":1000}; it only grants access when
+// every condition holds for the request attributes supplied through the Requirement.
+
+package main
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Condition is a single attribute test: Field Operator Value.
+type Condition struct {
+	Field    string      `bson:"field" json:"field"`
+	Operator string      `bson:"operator" json:"operator"`
+	Value    interface{} `bson:"value" json:"value"`
+}
+
+// conditionOps are the supported operators. lt and gt compare numbers only.
+var conditionOps = map[string]struct{}{"eq": {}, "ne": {}, "in": {}, "lt": {}, "gt": {}}
+
+/*
+validateConditions rejects unknown operators, missing fields, and "in" conditions whose
+value is not a list, so malformed roles are caught when they are written.
+*/
+func validateConditions(conditions []Condition) error {
+	for _, cond := range conditions {
+		if cond.Field == "" {
+			return fmt.Errorf("condition is missing field")
+		}
+		if _, ok := conditionOps[cond.Operator]; !ok {
+			return fmt.Errorf("condition on %q has unsupported operator %q (expected eq, ne, in, lt, or gt)", cond.Field, cond.Operator)
+		}
+		if _, ok := asList(cond.Value); cond.Operator == "in" && !ok {
+			return fmt.Errorf("condition on %q: value for operator in must be a list", cond.Field)
+		}
+	}
+	return nil
+}
+
+/*
+conditionsMet reports whether every condition holds for attrs. A condition on an attribute
+that is absent never holds, so conditional grants fail closed.
+*/
+func conditionsMet(conditions []Condition, attrs map[string]interface{}) bool {
+	for _, cond := range conditions {
+		actual, ok := attrs[cond.Field]
+		if !ok || !conditionHolds(cond, actual) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+conditionHolds evaluates a single condition against the attribute's actual value.
+*/
+func conditionHolds(cond Condition, actual interface{}) bool {
+	switch cond.Operator {
+	case "eq":
+		return valuesEqual(actual, cond.Value)
+	case "ne":
+		return !valuesEqual(actual, cond.Value)
+	case "in":
+		list, _ := asList(cond.Value)
+		for _, candidate := range list {
+			if valuesEqual(actual, candidate) {
+				return true
+			}
+		}
+		return false
+	case "lt", "gt":
+		a, okA := asNumber(actual)
+		b, okB := asNumber(cond.Value)
+		if !okA || !okB {
+			return false
+		}
+		if cond.Operator == "lt" {
+			return a < b
+		}
+		return a > b
+	}
+	return false
+}
+
+/*
+valuesEqual compares numbers numerically (so 1000 from JSON equals int32 1000 from BSON)
+and everything else by exact value.
+*/
+func valuesEqual(a, b interface{}) bool {
+	if x, ok := asNumber(a); ok {
+		y, ok := asNumber(b)
+		return ok && x == y
+	}
+	switch a.(type) {
+	case string, bool:
+		return a == b
+	}
+	return false
+}
+
+/*
+asNumber converts the numeric types produced by JSON, BSON, and Go callers to float64.
+*/
+func asNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+/*
+asList converts a condition value decoded from JSON or BSON, or set from Go, into a slice.
+*/
+func asList(v interface{}) ([]interface{}, bool) {
+	switch list := v.(type) {
+	case []interface{}:
+		return list, true
+	case primitive.A:
+		return list, true
+	case []string:
+		out := make([]interface{}, len(list))
+		for i, s := range list {
+			out[i] = s
+		}
+		return out, true
+	}
+	return nil, false
+}
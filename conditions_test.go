@@ -0,0 +1,64 @@
+// conditions_test.go
+//
+// Tests for attribute conditions on permissions.
+
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestConditionHolds(t *testing.T) {
+	tests := []struct {
+		name   string
+		cond   Condition
+		actual interface{}
+		want   bool
+	}{
+		{"lt int32 limit, float64 attribute", Condition{Field: "amount", Operator: "lt", Value: int32(1000)}, 999.5, true},
+		{"lt at the limit", Condition{Field: "amount", Operator: "lt", Value: int32(1000)}, float64(1000), false},
+		{"gt float64 limit, int32 attribute", Condition{Field: "amount", Operator: "gt", Value: 10.5}, int32(11), true},
+		{"gt int64 limit, int attribute", Condition{Field: "amount", Operator: "gt", Value: int64(10)}, 10, false},
+		{"eq across int32 and float64", Condition{Field: "level", Operator: "eq", Value: int32(3)}, float64(3), true},
+		{"ne across int32 and float64", Condition{Field: "level", Operator: "ne", Value: int32(3)}, float64(3), false},
+		{"lt non-numeric attribute", Condition{Field: "amount", Operator: "lt", Value: int32(1000)}, "999", false},
+		{"eq string", Condition{Field: "dept", Operator: "eq", Value: "hr"}, "hr", true},
+		{"eq string is not numeric", Condition{Field: "dept", Operator: "eq", Value: "1"}, 1, false},
+		{"in primitive.A of strings", Condition{Field: "dept", Operator: "in", Value: primitive.A{"hr", "finance"}}, "finance", true},
+		{"in primitive.A missing value", Condition{Field: "dept", Operator: "in", Value: primitive.A{"hr", "finance"}}, "sales", false},
+		{"in primitive.A of int32", Condition{Field: "level", Operator: "in", Value: primitive.A{int32(1), int32(2)}}, float64(2), true},
+		{"in []interface{}", Condition{Field: "dept", Operator: "in", Value: []interface{}{"hr"}}, "hr", true},
+		{"in non-list value", Condition{Field: "dept", Operator: "in", Value: "hr"}, "hr", false},
+		{"unknown operator", Condition{Field: "dept", Operator: "like", Value: "hr"}, "hr", false},
+	}
+	for _, tt := range tests {
+		if got := conditionHolds(tt.cond, tt.actual); got != tt.want {
+			t.Errorf("%s: conditionHolds = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConditionsMetFailsClosed(t *testing.T) {
+	conditions := []Condition{
+		{Field: "amount", Operator: "lt", Value: int32(1000)},
+		{Field: "dept", Operator: "in", Value: primitive.A{"hr"}},
+	}
+	if !conditionsMet(conditions, map[string]interface{}{"amount": 10.0, "dept": "hr"}) {
+		t.Error("conditions not met by matching attributes")
+	}
+	if conditionsMet(conditions, map[string]interface{}{"amount": 10.0}) {
+		t.Error("missing attribute satisfied a condition")
+	}
+	if conditionsMet(conditions, nil) {
+		t.Error("no attributes satisfied the conditions")
+	}
+	// A ne condition also fails closed: absence is not "not equal".
+	if conditionsMet([]Condition{{Field: "dept", Operator: "ne", Value: "sales"}}, nil) {
+		t.Error("missing attribute satisfied a ne condition")
+	}
+	if !conditionsMet(nil, nil) {
+		t.Error("a permission without conditions must always apply")
+	}
+}
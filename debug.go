@@ -0,0 +1,188 @@
+// debug.go
+//
+// Read-only introspection of the RBAC engine's effective runtime configuration, for
+// operators debugging why access decisions differ from what the stored policy suggests.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// cachedRoleSummary describes a cached role without its full permission bodies.
+type cachedRoleSummary struct {
+	RoleID          string `json:"role_id"`
+	Description     string `json:"description,omitempty"`
+	Disabled        bool   `json:"disabled"`
+	PermissionCount int    `json:"permission_count"`
+}
+
+/*
+debugConfigHandler returns the effective region mapping, composite region references,
+matching settings, and a summary of cached roles. Pass ?full=true to include the full
+cached role documents.
+*/
+func debugConfigHandler(c *fiber.Ctx) error {
+	cache := fiber.Map{"enabled": rolesCache != nil}
+	if rolesCache != nil {
+		roles := rolesCache.snapshot()
+		summaries := make([]cachedRoleSummary, 0, len(roles))
+		for id, role := range roles {
+			summaries = append(summaries, cachedRoleSummary{
+				RoleID:          id,
+				Description:     role.Description,
+				Disabled:        role.Disabled,
+				PermissionCount: len(role.Permissions),
+			})
+		}
+		sort.Slice(summaries, func(i, j int) bool { return summaries[i].RoleID < summaries[j].RoleID })
+		cache["ttl"] = rolesCache.ttl.String()
+		cache["max_entries"] = rolesCache.maxEntries
+		cache["roles"] = summaries
+		if c.QueryBool("full") {
+			cache["role_documents"] = roles
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"regions":           regionMap(),
+		"composite_regions": compositeRegions(),
+		"path_matching": fiber.Map{
+			"case_sensitive": pathCaseSensitive,
+			"separator":      pathSeparator,
+		},
+		"verification_mode": verificationMode(),
+		"role_cache":        cache,
+	})
+}
+
+/*
+verificationMode reports whether tokens are verified locally or trusted from the gateway.
+*/
+func verificationMode() string {
+	if tokenVerifier != nil {
+		return "jwks"
+	}
+	return "gateway"
+}
+
+// keycloakProbeClient is used by the Keycloak connectivity check.
+var keycloakProbeClient = &http.Client{Timeout: 5 * time.Second}
+
+/*
+keycloakURLs returns the configured issuer and JWKS URL, deriving the JWKS URL from the
+issuer as initTokenVerification does. Both are read even in gateway mode, so connectivity
+can be checked before switching to JWT_VERIFY_MODE=jwks.
+*/
+func keycloakURLs() (issuer, jwksURL string) {
+	issuer = os.Getenv("KEYCLOAK_ISSUER")
+	jwksURL = os.Getenv("KEYCLOAK_JWKS_URL")
+	if tokenVerifier != nil {
+		jwksURL = tokenVerifier.url
+	}
+	if jwksURL == "" && issuer != "" {
+		jwksURL = strings.TrimSuffix(issuer, "/") + "/protocol/openid-connect/certs"
+	}
+	return issuer, jwksURL
+}
+
+/*
+probeJSON fetches url and decodes its JSON body into out, reporting the outcome with the
+HTTP status and elapsed time.
+*/
+func probeJSON(url string, out interface{}) fiber.Map {
+	result := fiber.Map{"url": url, "ok": false}
+	start := time.Now()
+	resp, err := keycloakProbeClient.Get(url)
+	if err != nil {
+		result["duration_ms"] = time.Since(start).Milliseconds()
+		result["error"] = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	result["status"] = resp.StatusCode
+	if resp.StatusCode != http.StatusOK {
+		result["duration_ms"] = time.Since(start).Milliseconds()
+		result["error"] = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+		return result
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+	result["duration_ms"] = time.Since(start).Milliseconds()
+	if err != nil {
+		result["error"] = "invalid JSON document: " + err.Error()
+		return result
+	}
+	result["ok"] = true
+	return result
+}
+
+/*
+keycloakDebugHandler checks that Keycloak is reachable. It fetches the OIDC discovery
+document (when KEYCLOAK_ISSUER is set) and the JWKS, and reports for each: success, HTTP
+status, timing, and for the JWKS the number of keys with their kid, kty, alg, and use.
+Key material is never returned, and the verifier's cached key set is left untouched.
+*/
+func keycloakDebugHandler(c *fiber.Ctx) error {
+	issuer, jwksURL := keycloakURLs()
+	body := fiber.Map{"verification_mode": verificationMode()}
+	ok := true
+
+	if issuer != "" {
+		var doc struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		discovery := probeJSON(strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &doc)
+		if discovery["ok"] == true {
+			discovery["issuer"] = doc.Issuer
+			discovery["issuer_matches"] = doc.Issuer == issuer
+			discovery["jwks_uri"] = doc.JWKSURI
+		}
+		ok = ok && discovery["ok"] == true
+		body["discovery"] = discovery
+	}
+
+	if jwksURL == "" {
+		body["jwks"] = fiber.Map{"ok": false, "error": "neither KEYCLOAK_JWKS_URL nor KEYCLOAK_ISSUER is set"}
+		ok = false
+	} else {
+		var set struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		jwks := probeJSON(jwksURL, &set)
+		if jwks["ok"] == true {
+			keys := make([]fiber.Map, 0, len(set.Keys))
+			for _, k := range set.Keys {
+				keys = append(keys, fiber.Map{"kid": k.Kid, "kty": k.Kty, "alg": k.Alg, "use": k.Use})
+			}
+			jwks["key_count"] = len(set.Keys)
+			jwks["keys"] = keys
+			if len(set.Keys) == 0 {
+				jwks["ok"], jwks["error"] = false, "JWKS contains no keys"
+			}
+		}
+		ok = ok && jwks["ok"] == true
+		body["jwks"] = jwks
+	}
+
+	if tokenVerifier != nil {
+		tokenVerifier.mu.RLock()
+		body["cached_keys"] = len(tokenVerifier.keys)
+		body["cached_at"] = timestamp(tokenVerifier.fetchedAt)
+		tokenVerifier.mu.RUnlock()
+	}
+	body["ok"] = ok
+	if !ok {
+		return c.Status(fiber.StatusBadGateway).JSON(body)
+	}
+	return c.JSON(body)
+}
@@ -0,0 +1,194 @@
+// decisioncache.go
+//
+// Optional short-TTL cache of permission decisions for hot endpoints hit repeatedly by the
+// same users. The key hashes everything Decide reads: the user's role ids, path, country,
+// owner and subject, target and token audiences, user override countries, and request
+// attributes, plus a generation number bumped whenever cached roles change, so an edited
+// role never serves a decision computed from its previous definition.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// decisionCacheEntry is a cached decision with its expiry time.
+type decisionCacheEntry struct {
+	decision  Decision
+	expiresAt time.Time
+}
+
+// decisionCache is a concurrency-safe TTL cache of decisions keyed by decisionKey.
+type decisionCache struct {
+	mu         sync.RWMutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]decisionCacheEntry
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+var (
+	// decisions is the process-wide decision cache. It is nil when caching is disabled.
+	decisions *decisionCache
+	// decisionGeneration is part of every key; bumping it invalidates all cached decisions.
+	decisionGeneration atomic.Uint64
+)
+
+/*
+invalidateDecisions drops every cached decision by moving to a new key generation.
+*/
+func invalidateDecisions() {
+	decisionGeneration.Add(1)
+}
+
+/*
+initDecisionCache enables the decision cache when DECISION_CACHE_TTL is a positive duration
+(e.g. "5s"). DECISION_CACHE_MAX bounds the number of entries (default 10000); when full, new
+decisions are computed but not stored until the sweeper frees space.
+*/
+func initDecisionCache() {
+	ttlStr := os.Getenv("DECISION_CACHE_TTL")
+	if ttlStr == "" {
+		return
+	}
+	ttl, err := time.ParseDuration(ttlStr)
+	if err != nil || ttl <= 0 {
+		log.Fatalf("Invalid DECISION_CACHE_TTL %q: must be a positive duration such as 5s", ttlStr)
+	}
+	maxEntries := 10000
+	if v := os.Getenv("DECISION_CACHE_MAX"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid DECISION_CACHE_MAX %q: must be a positive integer", v)
+		}
+		maxEntries = n
+	}
+
+	dc := &decisionCache{ttl: ttl, maxEntries: maxEntries, entries: make(map[string]decisionCacheEntry)}
+	decisions = dc
+
+	registerCounter("rbac_decision_cache_hits_total", "Permission decisions served from the decision cache.", dc.hits.Load)
+	registerCounter("rbac_decision_cache_misses_total", "Permission decisions computed because no cached decision existed.", dc.misses.Load)
+	registerGauge("rbac_decision_cache_size", "Number of decisions currently cached.", func() float64 {
+		dc.mu.RLock()
+		defer dc.mu.RUnlock()
+		return float64(len(dc.entries))
+	})
+	registerGauge("rbac_decision_cache_hit_ratio", "Decision cache hits / (hits + misses).", func() float64 {
+		hits, misses := dc.hits.Load(), dc.misses.Load()
+		if hits+misses == 0 {
+			return 0
+		}
+		return float64(hits) / float64(hits+misses)
+	})
+
+	go func() {
+		for range time.Tick(ttl) {
+			dc.sweep()
+		}
+	}()
+	logInfo("decision cache enabled", "ttl", ttl, "max_entries", maxEntries)
+}
+
+/*
+decisionKey hashes every input of Decide for the user and requirement.
+*/
+func decisionKey(user *User, req Requirement) string {
+	roleIDs := make([]string, len(user.Roles))
+	for i, role := range user.Roles {
+		roleIDs[i] = role.RoleID
+	}
+	sort.Strings(roleIDs)
+	audiences := append([]string(nil), user.Audiences...)
+	sort.Strings(audiences)
+	// The allowed countries claim can narrow the countries of users holding the same roles.
+	countries := append([]string(nil), user.AllowedCountries...)
+	sort.Strings(countries)
+	attributes, _ := json.Marshal(req.Attributes) // map keys are marshaled in sorted order
+
+	h := sha256.New()
+	for _, part := range []string{
+		strconv.FormatUint(decisionGeneration.Load(), 10),
+		strings.Join(roleIDs, ","),
+		req.Path,
+		req.Country,
+		req.Owner,
+		user.Subject,
+		targetAudience(req),
+		strings.Join(audiences, ","),
+		strings.Join(user.addedCountries, ","),
+		strings.Join(user.removedCountries, ","),
+		strings.Join(countries, ","),
+		string(attributes),
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+/*
+cachedDecide is Decide served from the decision cache when it is enabled. Every decision is
+logged at debug level, so LOG_LEVEL=debug shows why a request was allowed or denied.
+*/
+func cachedDecide(user *User, req Requirement) Decision {
+	decision, cached := lookupDecision(user, req)
+	if logEnabled(levelDebug) {
+		logDebug("decision", "user", logSafe(user.ID), "path", req.Path, "country", req.Country,
+			"allowed", decision.Allowed, "reason", decision.String(), "cached", cached)
+	}
+	return decision
+}
+
+/*
+lookupDecision returns the cached decision for the user and requirement, computing and
+storing it on a miss. cached reports whether it was served from the cache.
+*/
+func lookupDecision(user *User, req Requirement) (decision Decision, cached bool) {
+	dc := decisions
+	if dc == nil {
+		return Decide(user, req), false
+	}
+	key := decisionKey(user, req)
+	dc.mu.RLock()
+	entry, ok := dc.entries[key]
+	dc.mu.RUnlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		dc.hits.Add(1)
+		return entry.decision, true
+	}
+	dc.misses.Add(1)
+	decision = Decide(user, req)
+	dc.mu.Lock()
+	if len(dc.entries) < dc.maxEntries || ok {
+		dc.entries[key] = decisionCacheEntry{decision: decision, expiresAt: time.Now().Add(dc.ttl)}
+	}
+	dc.mu.Unlock()
+	return decision, false
+}
+
+/*
+sweep removes expired decisions.
+*/
+func (dc *decisionCache) sweep() {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	now := time.Now()
+	for key, entry := range dc.entries {
+		if now.After(entry.expiresAt) {
+			delete(dc.entries, key)
+		}
+	}
+}
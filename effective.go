@@ -0,0 +1,151 @@
+// effective.go
+//
+// A role-agnostic view of a user's permissions: the grants of all roles merged so each
+// distinct rule appears once, with its country scope combined across roles.
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+/*
+MergeEffectivePermissions merges the permissions of all the user's roles into a
+deduplicated, sorted list that Decide evaluates the same way as the original roles.
+
+Merge rules:
+  - Permissions merge only when everything except their geography is identical: path,
+    except_paths, self_only, priority, conditions, and audiences. Rules differing in any of
+    these decide differently and stay separate.
+  - Each permission's regions and exclusions are resolved first (as in effectiveCountries),
+    then the merged rule grants the union of the resulting countries. An exclusion therefore
+    only narrows the role it was written in: a country excluded by one role but granted by
+    another is granted, as Decide would allow it through the other role.
+  - When any of the merged permissions is global, the result is a global grant whose
+    except_countries are the countries excluded by every global permission and granted by
+    none of the others. It is the GLOBAL region when one of them used it, and countries ["*"]
+    otherwise, since only the region satisfies GLOBAL requirements.
+  - except_paths are kept on every rule that carries them. They deny at their priority no
+    matter which role grants the path, so they are never dropped or combined.
+  - The description is the first non-empty one in role order.
+
+User-level country overrides are not part of any role and are not reflected here.
+*/
+func MergeEffectivePermissions(user *User) []Permission {
+	type mergedRule struct {
+		perm      Permission
+		countries map[string]struct{}
+		global    bool
+		// globalRegion records that a global permission used the GLOBAL region.
+		globalRegion bool
+		// globalExcept counts, per country, how many global permissions excluded it.
+		globalExcept map[string]int
+		globals      int
+	}
+	rules := make(map[string]*mergedRule)
+	var order []string
+	for _, role := range user.Roles {
+		for _, perm := range role.Permissions {
+			key := mergeKey(perm)
+			rule, ok := rules[key]
+			if !ok {
+				rule = &mergedRule{
+					perm: Permission{
+						Path:        perm.Path,
+						ExceptPaths: sortedCopy(perm.ExceptPaths),
+						SelfOnly:    perm.SelfOnly,
+						Priority:    perm.Priority,
+						Conditions:  perm.Conditions,
+						Audiences:   sortedCopy(perm.Audiences),
+					},
+					countries:    make(map[string]struct{}),
+					globalExcept: make(map[string]int),
+				}
+				rules[key] = rule
+				order = append(order, key)
+			}
+			if rule.perm.Description == "" {
+				rule.perm.Description = perm.Description
+			}
+			countries, except := effectiveCountries(perm)
+			if len(countries) == 1 && countries[0] == "*" {
+				rule.global = true
+				rule.globalRegion = rule.globalRegion || grantsGlobalRegion(perm)
+				rule.globals++
+				for _, c := range except {
+					rule.globalExcept[c]++
+				}
+				continue
+			}
+			for _, c := range countries {
+				rule.countries[c] = struct{}{}
+			}
+		}
+	}
+
+	merged := make([]Permission, 0, len(order))
+	for _, key := range order {
+		rule := rules[key]
+		perm := rule.perm
+		if rule.global {
+			// Only a GLOBAL region also satisfies GLOBAL requirements; Countries ["*"] does not.
+			if rule.globalRegion {
+				perm.Regions = []string{"GLOBAL"}
+			} else {
+				perm.Countries = []string{"*"}
+			}
+			for c, n := range rule.globalExcept {
+				if _, granted := rule.countries[c]; n == rule.globals && !granted {
+					perm.ExceptCountries = append(perm.ExceptCountries, c)
+				}
+			}
+			sort.Strings(perm.ExceptCountries)
+		} else {
+			for c := range rule.countries {
+				perm.Countries = append(perm.Countries, c)
+			}
+			sort.Strings(perm.Countries)
+		}
+		merged = append(merged, perm)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].Path != merged[j].Path {
+			return merged[i].Path < merged[j].Path
+		}
+		return merged[i].Priority > merged[j].Priority
+	})
+	return merged
+}
+
+/*
+mergeKey identifies the non-geographic part of a permission, so permissions that only
+differ in countries and regions share a key.
+*/
+func mergeKey(perm Permission) string {
+	conditions := perm.Conditions
+	if len(conditions) == 0 {
+		conditions = nil
+	}
+	key, _ := json.Marshal(struct {
+		Path        string
+		ExceptPaths []string
+		SelfOnly    bool
+		Priority    int
+		Conditions  []Condition
+		Audiences   []string
+	}{perm.Path, sortedCopy(perm.ExceptPaths), perm.SelfOnly, perm.Priority, conditions, sortedCopy(perm.Audiences)})
+	return string(key)
+}
+
+/*
+sortedCopy returns a sorted copy of values, or nil when it is empty.
+*/
+func sortedCopy(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	out := append([]string(nil), values...)
+	sort.Strings(out)
+	return out
+}
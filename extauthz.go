@@ -0,0 +1,63 @@
+// extauthz.go
+//
+// Envoy HTTP ext_authz endpoint. Envoy forwards the original method, path (under the
+// configured path_prefix, here /ext_authz), and Authorization header; the path is looked
+// up in the route-to-permission table and evaluated by the same permission middleware as
+// this service's own routes. 200 allows the request and any other status denies it.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// extAuthzPrefix is the path_prefix Envoy must be configured with for this service.
+const extAuthzPrefix = "/ext_authz"
+
+// extAuthzMethods are the forwarded methods the endpoint answers; GET implies HEAD.
+var extAuthzMethods = []string{
+	fiber.MethodGet, fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete,
+}
+
+/*
+extAuthzCheck resolves the forwarded request's Requirement and runs its permission
+middleware, which continues to extAuthzAllow on success. Unmapped routes are denied.
+*/
+func extAuthzCheck(c *fiber.Ctx) error {
+	path := strings.TrimPrefix(c.Path(), extAuthzPrefix)
+	if path == "" {
+		path = "/"
+	}
+	route, ok := lookupRoutePolicy(c.Method(), path)
+	if !ok {
+		return deny(c, fiber.StatusForbidden, "No permission mapping for "+c.Method()+" "+path)
+	}
+	return route.guard(c)
+}
+
+/*
+extAuthzAllow answers an allowed check with 200 and identity headers that Envoy can pass
+upstream via allowed_upstream_headers. With INTERNAL_TOKEN_SECRET set, the slim internal
+token is returned as the Authorization header so Envoy replaces the original token upstream.
+*/
+func extAuthzAllow(c *fiber.Ctx) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	c.Set("X-Auth-User", user.ID)
+	if user.Subject != "" {
+		c.Set("X-Auth-Subject", user.Subject)
+	}
+	if internalTokenKey != nil {
+		token, err := mintInternalToken(user)
+		if err != nil {
+			logError("internal token signing failed", "user", logSafe(user.ID), "error", err)
+			return deny(c, fiber.StatusInternalServerError, "failed to issue internal token")
+		}
+		c.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
@@ -0,0 +1,135 @@
+// flags.go
+//
+// Feature flags for progressive rollout. A Requirement naming a Feature is only satisfied
+// when the permission check passes AND the flag is on for the caller, so an endpoint can be
+// opened to one role or a handful of users before everyone. Flags come from FEATURE_FLAGS
+// and the MongoDB "feature_flags" collection, refreshed periodically.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// FeatureFlag is a "feature_flags" document. The flag is on for everyone when Enabled is
+// set, and otherwise only for the listed users (by username) and holders of the listed roles.
+type FeatureFlag struct {
+	Name    string   `bson:"name" json:"name"`
+	Enabled bool     `bson:"enabled" json:"enabled"`
+	Users   []string `bson:"users,omitempty" json:"users,omitempty"`
+	Roles   []string `bson:"roles,omitempty" json:"roles,omitempty"`
+}
+
+// featureDisabledCode is the denial code of a disabled feature when FEATURE_FLAG_OFF_STATUS=403.
+const featureDisabledCode = "FEATURE_DISABLED"
+
+var (
+	// featureFlagsMu guards configuredFlags and storedFlags.
+	featureFlagsMu sync.RWMutex
+	// configuredFlags are the FEATURE_FLAGS entries; storedFlags the MongoDB documents, which
+	// replace a configured flag of the same name.
+	configuredFlags map[string]FeatureFlag
+	storedFlags     map[string]FeatureFlag
+	// featureOffStatus is the status of a request to a disabled feature: 404 (the default)
+	// hides the endpoint, 403 reports it with code FEATURE_DISABLED.
+	featureOffStatus = 404
+)
+
+/*
+initFeatureFlags loads FEATURE_FLAGS, a JSON array of flags shaped like the documents,
+e.g. [{"name":"new_payroll","roles":["hr_manager"]}], and the "feature_flags" collection,
+which is reloaded every FEATURE_FLAGS_REFRESH (30s). FEATURE_FLAG_OFF_STATUS is 404 or 403.
+*/
+func initFeatureFlags() {
+	switch v := os.Getenv("FEATURE_FLAG_OFF_STATUS"); v {
+	case "", "404":
+	case "403":
+		featureOffStatus = 403
+	default:
+		log.Fatalf("Invalid FEATURE_FLAG_OFF_STATUS %q: expected 404 or 403", v)
+	}
+	refresh := envDuration("FEATURE_FLAGS_REFRESH", 30*time.Second)
+
+	configured := make(map[string]FeatureFlag)
+	if v := os.Getenv("FEATURE_FLAGS"); v != "" {
+		var flags []FeatureFlag
+		if err := json.Unmarshal([]byte(v), &flags); err != nil {
+			log.Fatalf("Invalid FEATURE_FLAGS: %v", err)
+		}
+		for _, flag := range flags {
+			if flag.Name == "" {
+				log.Fatal("Invalid FEATURE_FLAGS: a flag has no name")
+			}
+			configured[flag.Name] = flag
+		}
+	}
+	featureFlagsMu.Lock()
+	configuredFlags = configured
+	featureFlagsMu.Unlock()
+
+	if err := reloadFeatureFlags(); err != nil {
+		log.Fatal("Failed to load feature flags:", err)
+	}
+	go func() {
+		for range time.Tick(refresh) {
+			if err := reloadFeatureFlags(); err != nil {
+				logWarn("feature flag refresh failed", "error", err)
+			}
+		}
+	}()
+}
+
+/*
+reloadFeatureFlags replaces the stored flags with the current "feature_flags" documents.
+*/
+func reloadFeatureFlags() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cursor, err := mongoDB.Collection("feature_flags").Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var docs []FeatureFlag
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+	stored := make(map[string]FeatureFlag, len(docs))
+	for _, doc := range docs {
+		stored[doc.Name] = doc
+	}
+	featureFlagsMu.Lock()
+	storedFlags = stored
+	featureFlagsMu.Unlock()
+	return nil
+}
+
+/*
+featureEnabled reports whether the named flag is on for the user. An unknown flag is off.
+*/
+func featureEnabled(name string, user *User) bool {
+	featureFlagsMu.RLock()
+	flag, ok := storedFlags[name]
+	if !ok {
+		flag, ok = configuredFlags[name]
+	}
+	featureFlagsMu.RUnlock()
+	if !ok {
+		return false
+	}
+	if flag.Enabled || containsExact(flag.Users, user.ID) {
+		return true
+	}
+	for _, role := range user.Roles {
+		if containsExact(flag.Roles, role.RoleID) {
+			return true
+		}
+	}
+	return false
+}
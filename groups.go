@@ -0,0 +1,139 @@
+// groups.go
+//
+// Role extraction from an IdP groups claim. Keycloak can emit group memberships as full
+// paths (e.g. ["/hr/managers"]) instead of roles; a group-to-role mapping translates them
+// into role ids, which are then resolved like any role from the roles claim. A member of a
+// nested group also receives the roles mapped to every ancestor group, as in Keycloak.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// groupRoleMapping is a "group_role_mappings" document mapping a group path to role ids.
+type groupRoleMapping struct {
+	Group string   `bson:"group" json:"group"`
+	Roles []string `bson:"roles" json:"roles"`
+}
+
+var (
+	// groupsClaim is the claim holding the caller's group paths (JWT_GROUPS_CLAIM).
+	groupsClaim = "groups"
+	// groupRoles maps normalized group paths to role ids. Empty disables group roles.
+	groupRoles map[string][]string
+)
+
+/*
+initGroupMappings loads the group-to-role mapping from GROUP_ROLE_MAPPING_FILE, a JSON
+object such as {"/hr/managers":["hr_manager"]}, then from the MongoDB
+"group_role_mappings" collection; a MongoDB document replaces a file entry for the same
+group. JWT_GROUPS_CLAIM names the claim to read (default "groups").
+*/
+func initGroupMappings() {
+	if v := os.Getenv("JWT_GROUPS_CLAIM"); v != "" {
+		groupsClaim = v
+	}
+	mapping := make(map[string][]string)
+	if path := os.Getenv("GROUP_ROLE_MAPPING_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read GROUP_ROLE_MAPPING_FILE %s: %v", path, err)
+		}
+		var fileMapping map[string][]string
+		if err := json.Unmarshal(data, &fileMapping); err != nil {
+			log.Fatalf("Invalid GROUP_ROLE_MAPPING_FILE %s: %v", path, err)
+		}
+		for group, roles := range fileMapping {
+			addGroupMapping(mapping, group, roles)
+		}
+		logInfo("loaded group role mappings", "count", len(fileMapping), "source", path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cursor, err := mongoDB.Collection("group_role_mappings").Find(ctx, bson.M{})
+	if err != nil {
+		log.Fatal("Failed to load group role mappings from MongoDB:", err)
+	}
+	var docs []groupRoleMapping
+	if err := cursor.All(ctx, &docs); err != nil {
+		log.Fatal("Failed to load group role mappings from MongoDB:", err)
+	}
+	for _, doc := range docs {
+		addGroupMapping(mapping, doc.Group, doc.Roles)
+	}
+	if len(docs) > 0 {
+		logInfo("loaded group role mappings", "count", len(docs), "source", "mongo")
+	}
+	if len(mapping) > 0 {
+		groupRoles = mapping
+		logInfo("reading group roles from claim", "claim", groupsClaim)
+	}
+}
+
+/*
+addGroupMapping stores the roles of one group under its normalized path, refusing an entry
+that names no group.
+*/
+func addGroupMapping(mapping map[string][]string, group string, roles []string) {
+	path := normalizeGroupPath(group)
+	if path == "" {
+		log.Fatalf("Invalid group role mapping %q: group path is empty", group)
+	}
+	mapping[path] = roles
+}
+
+/*
+normalizeGroupPath converts a group to its full-path form: a leading slash, no trailing
+slash, and no empty segments, so "hr/managers", "/hr/managers/", and "/hr//managers" are
+the same group. It returns "" for a group with no name.
+*/
+func normalizeGroupPath(group string) string {
+	var segments []string
+	for _, segment := range strings.Split(group, "/") {
+		if segment = strings.TrimSpace(segment); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 {
+		return ""
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+/*
+groupRoleIDs translates the groups claim into role ids. Each group also contributes the
+roles of its ancestors: "/hr/managers" maps through "/hr/managers" and "/hr". It reports
+false when group mapping is disabled or the token carries no groups claim.
+*/
+func groupRoleIDs(claims jwt.MapClaims) ([]string, bool) {
+	if len(groupRoles) == 0 {
+		return nil, false
+	}
+	groups, ok := claims[groupsClaim].([]interface{})
+	if !ok {
+		return nil, false
+	}
+	var ids []string
+	for _, g := range groups {
+		name, ok := g.(string)
+		if !ok {
+			continue
+		}
+		path := normalizeGroupPath(name)
+		for path != "" {
+			ids = append(ids, groupRoles[path]...)
+			path = path[:strings.LastIndex(path, "/")]
+		}
+	}
+	return ids, true
+}
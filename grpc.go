@@ -0,0 +1,53 @@
+// grpc.go
+//
+// Optional gRPC policy decision point (GRPC_PORT). Services that cannot embed this code
+// call Authorization.Check with a user's token, a permission path, and a country, and get
+// the same decision the HTTP middleware would make. The API is defined in proto/authz.proto.
+
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+
+	"github.com/example/fiber-demo/authzpb"
+	"google.golang.org/grpc"
+)
+
+// authorizationServer implements authzpb.AuthorizationServer on top of IsAllowed.
+type authorizationServer struct {
+	authzpb.UnimplementedAuthorizationServer
+}
+
+/*
+Check evaluates the request with evaluateAccess. Denials are reported in the response
+rather than as gRPC errors, so callers only need to handle transport failures separately.
+*/
+func (authorizationServer) Check(ctx context.Context, in *authzpb.CheckRequest) (*authzpb.CheckResponse, error) {
+	decision := evaluateAccess(in.GetToken(), Requirement{Path: in.GetPath(), Country: in.GetCountry()})
+	return &authzpb.CheckResponse{Allowed: decision.Allowed, Reason: decision.Reason, Detail: decision.Detail}, nil
+}
+
+/*
+initGRPC starts the gRPC authorization server in the background when GRPC_PORT is set.
+*/
+func initGRPC() {
+	port := os.Getenv("GRPC_PORT")
+	if port == "" {
+		return
+	}
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatalf("Failed to listen on GRPC_PORT %s: %v", port, err)
+	}
+	server := grpc.NewServer()
+	authzpb.RegisterAuthorizationServer(server, authorizationServer{})
+	go func() {
+		if err := server.Serve(lis); err != nil {
+			log.Fatal("gRPC server stopped:", err)
+		}
+	}()
+	logInfo("gRPC authorization server started", "port", port)
+}
@@ -0,0 +1,117 @@
+// health.go
+//
+// Health endpoint for probes and operators. Besides pinging MongoDB it reports how fresh
+// the data the RBAC engine decides on is: role cache contents and last refresh, region
+// definitions, the token denylist, and JWKS keys. Optional subsystems that are disabled
+// are reported as such rather than failing the check.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+/*
+timestamp formats t as RFC 3339, or nil when t is zero (never happened).
+*/
+func timestamp(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+/*
+healthHandler reports overall status and per-subsystem freshness. It returns 503 when
+MongoDB is unreachable (both connections, when a read-only fallback is configured) or region
+definitions never loaded, and 200 otherwise.
+*/
+func healthHandler(c *fiber.Ctx) error {
+	healthy := true
+
+	mongoStatus := fiber.Map{"ok": false}
+	if mongoClient != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := mongoClient.Ping(ctx, nil); err != nil {
+			mongoStatus["error"] = err.Error()
+		} else {
+			mongoStatus["ok"] = true
+		}
+	}
+	mongoStatus["active"] = activeMongoConnection()
+	if mongoReadOnlyClient != nil {
+		readOnly := fiber.Map{"ok": false}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := mongoReadOnlyClient.Ping(ctx, nil); err != nil {
+			readOnly["error"] = err.Error()
+		} else {
+			readOnly["ok"] = true
+		}
+		mongoStatus["readonly"] = readOnly
+		// Authorization keeps working on the replica, so only a double outage is unhealthy.
+		if mongoStatus["ok"] != true && readOnly["ok"] == true {
+			mongoStatus["degraded"] = true
+			mongoStatus["ok"] = true
+		}
+	}
+	if mongoStatus["ok"] != true {
+		healthy = false
+	}
+
+	regionsMu.RLock()
+	loadedAt := regionsLoadedAt
+	regionCount := len(effectiveRegions)
+	regionsMu.RUnlock()
+	if loadedAt.IsZero() {
+		healthy = false
+	}
+
+	cache := fiber.Map{"enabled": rolesCache != nil, "backend": cacheBackendName()}
+	if rolesCache != nil {
+		var lastRefresh time.Time
+		if ns := rolesCache.lastRefresh.Load(); ns > 0 {
+			lastRefresh = time.Unix(0, ns)
+		}
+		cache["cached_roles"] = rolesCache.size()
+		cache["last_refresh"] = timestamp(lastRefresh)
+	}
+
+	denylist := fiber.Map{"enabled": revokedTokens != nil}
+	if revokedTokens != nil {
+		revokedTokens.mu.RLock()
+		denylist["entries"] = len(revokedTokens.entries)
+		denylist["last_reload"] = timestamp(revokedTokens.reloadedAt)
+		revokedTokens.mu.RUnlock()
+	}
+
+	jwks := fiber.Map{"enabled": tokenVerifier != nil}
+	if tokenVerifier != nil {
+		tokenVerifier.mu.RLock()
+		jwks["keys"] = len(tokenVerifier.keys)
+		jwks["last_fetch"] = timestamp(tokenVerifier.fetchedAt)
+		tokenVerifier.mu.RUnlock()
+	}
+
+	status, code := "ok", fiber.StatusOK
+	if !healthy {
+		status, code = "unavailable", fiber.StatusServiceUnavailable
+	}
+	return c.Status(code).JSON(fiber.Map{
+		"status": status,
+		"mongo":  mongoStatus,
+		"regions": fiber.Map{
+			"loaded":    !loadedAt.IsZero(),
+			"count":     regionCount,
+			"loaded_at": timestamp(loadedAt),
+		},
+		"role_cache":   cache,
+		"jti_denylist": denylist,
+		"jwks":         jwks,
+		"role_watcher": fiber.Map{"enabled": false},
+	})
+}
@@ -0,0 +1,103 @@
+// internaltoken.go
+//
+// Optional slim internal token for hops behind this service. When INTERNAL_TOKEN_SECRET is
+// set, every authorized request has its Authorization header replaced with a short-lived
+// HS256 token carrying only the user id, subject, resolved roles, and allowed countries, so
+// downstream services parse less and the original IdP token goes no further. For ext_authz
+// the slim token is returned as an Authorization response header for Envoy to forward.
+
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// minInternalTokenSecret is the shortest accepted HS256 signing key, in bytes.
+const minInternalTokenSecret = 32
+
+var (
+	// internalTokenKey signs internal tokens. Nil disables re-signing.
+	internalTokenKey []byte
+	// internalTokenTTL is the lifetime of an internal token.
+	internalTokenTTL = 60 * time.Second
+	// internalTokenIssuer is the iss claim of internal tokens.
+	internalTokenIssuer = "rbac-gateway"
+	// internalTokenAudience is the optional aud claim of internal tokens.
+	internalTokenAudience string
+)
+
+/*
+initInternalToken reads INTERNAL_TOKEN_SECRET, INTERNAL_TOKEN_TTL, INTERNAL_TOKEN_ISSUER,
+and INTERNAL_TOKEN_AUDIENCE. It must run before routes are registered.
+*/
+func initInternalToken() {
+	secret := os.Getenv("INTERNAL_TOKEN_SECRET")
+	if secret == "" {
+		return
+	}
+	if len(secret) < minInternalTokenSecret {
+		log.Fatalf("INTERNAL_TOKEN_SECRET must be at least %d bytes", minInternalTokenSecret)
+	}
+	if v := os.Getenv("INTERNAL_TOKEN_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			log.Fatalf("Invalid INTERNAL_TOKEN_TTL %q: must be a positive duration such as 60s", v)
+		}
+		internalTokenTTL = ttl
+	}
+	if v := os.Getenv("INTERNAL_TOKEN_ISSUER"); v != "" {
+		internalTokenIssuer = v
+	}
+	internalTokenAudience = os.Getenv("INTERNAL_TOKEN_AUDIENCE")
+	internalTokenKey = []byte(secret)
+	logInfo("forwarding slim internal tokens", "issuer", internalTokenIssuer, "ttl", internalTokenTTL)
+}
+
+/*
+mintInternalToken signs the slim token for an authorized user.
+*/
+func mintInternalToken(user *User) (string, error) {
+	roles := make([]string, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		roles = append(roles, role.RoleID)
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                internalTokenIssuer,
+		"sub":                user.Subject,
+		"preferred_username": user.ID,
+		"roles":              roles,
+		"countries":          user.AllowedCountries,
+		"iat":                now.Unix(),
+		"exp":                now.Add(internalTokenTTL).Unix(),
+	}
+	if internalTokenAudience != "" {
+		claims["aud"] = internalTokenAudience
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(internalTokenKey)
+}
+
+/*
+forwardInternalToken is the middleware that replaces the request's Authorization header with
+the slim token of the user resolved by the preceding guard, so handlers that forward the
+header pass on the internal token only. A signing failure is a 500, never a silent pass-through
+of the original token.
+*/
+func forwardInternalToken(c *fiber.Ctx) error {
+	user, ok := c.Locals("user").(*User)
+	if !ok {
+		return c.Next()
+	}
+	token, err := mintInternalToken(user)
+	if err != nil {
+		logError("internal token signing failed", "user", logSafe(user.ID), "error", err)
+		return deny(c, fiber.StatusInternalServerError, "failed to issue internal token")
+	}
+	c.Request().Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	return c.Next()
+}
@@ -0,0 +1,345 @@
+// jwks.go
+//
+// Optional local JWT signature verification against a JWKS endpoint (e.g. Keycloak's
+// certs endpoint). By default the backend trusts KrakenD to have verified the token;
+// JWT_VERIFY_MODE=jwks makes it verify signatures itself, accepting only the signing
+// algorithms in JWT_ALLOWED_ALGS, with optional pinning of the accepted signing keys by
+// their RFC 7638 SHA-256 thumbprint.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// jsonWebKey is the subset of RFC 7517 key members needed for RSA and EC signing keys.
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwksVerifier fetches and caches signing keys from a JWKS URL and verifies tokens with them.
+type jwksVerifier struct {
+	url        string
+	issuer     string
+	pinned     map[string]struct{}
+	algs       []string
+	refresh    time.Duration
+	httpClient *http.Client
+
+	mu        sync.RWMutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// tokenVerifier is the active JWKS verifier. It is nil in gateway-trust mode.
+var tokenVerifier *jwksVerifier
+
+/*
+initTokenVerification enables local signature verification when JWT_VERIFY_MODE=jwks.
+The JWKS URL comes from KEYCLOAK_JWKS_URL or is derived from KEYCLOAK_ISSUER, and
+JWKS_PINNED_THUMBPRINTS optionally restricts the keys that will ever be accepted.
+JWT_ALLOWED_ALGS restricts the accepted signing algorithms (default RS256,ES256).
+*/
+func initTokenVerification() {
+	mode := os.Getenv("JWT_VERIFY_MODE")
+	if mode == "" || mode == "gateway" {
+		logInfo("JWT signatures verified by the gateway", "mode", "gateway")
+		return
+	}
+	if mode != "jwks" {
+		log.Fatalf("Invalid JWT_VERIFY_MODE %q: expected gateway or jwks", mode)
+	}
+
+	issuer := os.Getenv("KEYCLOAK_ISSUER")
+	url := os.Getenv("KEYCLOAK_JWKS_URL")
+	if url == "" && issuer != "" {
+		url = strings.TrimSuffix(issuer, "/") + "/protocol/openid-connect/certs"
+	}
+	if url == "" {
+		log.Fatal("JWT_VERIFY_MODE=jwks requires KEYCLOAK_JWKS_URL or KEYCLOAK_ISSUER")
+	}
+
+	refresh := 15 * time.Minute
+	if v := os.Getenv("JWKS_REFRESH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid JWKS_REFRESH_INTERVAL %q: must be a positive duration such as 15m", v)
+		}
+		refresh = d
+	}
+
+	algs, err := parseAllowedAlgs(os.Getenv("JWT_ALLOWED_ALGS"))
+	if err != nil {
+		log.Fatalf("Invalid JWT_ALLOWED_ALGS: %v", err)
+	}
+
+	v := &jwksVerifier{
+		url:        url,
+		issuer:     issuer,
+		pinned:     parsePinnedThumbprints(os.Getenv("JWKS_PINNED_THUMBPRINTS")),
+		algs:       algs,
+		refresh:    refresh,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		keys:       make(map[string]interface{}),
+	}
+	if err := v.fetchKeys(); err != nil {
+		// Keycloak may still be starting; keys are fetched again on first use.
+		logWarn("initial JWKS fetch failed", "url", url, "error", err)
+	}
+	tokenVerifier = v
+	logInfo("JWT signatures verified against JWKS", "mode", "jwks", "url", url,
+		"algorithms", algs, "pinned_thumbprints", len(v.pinned))
+}
+
+// defaultAllowedAlgs are the signing algorithms accepted when JWT_ALLOWED_ALGS is not set.
+const defaultAllowedAlgs = "RS256,ES256"
+
+/*
+parseAllowedAlgs parses the comma-separated JWT_ALLOWED_ALGS value. Only asymmetric RSA
+(RS*, PS*) and ECDSA (ES*) algorithms are accepted: "none" is never allowed, and HMAC
+algorithms cannot be verified against a JWKS of public keys, so both are rejected here.
+*/
+func parseAllowedAlgs(raw string) ([]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		raw = defaultAllowedAlgs
+	}
+	var algs []string
+	for _, alg := range strings.Split(raw, ",") {
+		alg = strings.TrimSpace(alg)
+		if alg == "" {
+			continue
+		}
+		method := jwt.GetSigningMethod(alg)
+		switch method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+			algs = append(algs, method.Alg())
+		default:
+			return nil, fmt.Errorf("algorithm %q is not allowed; use RS*, PS*, or ES* algorithms", alg)
+		}
+	}
+	if len(algs) == 0 {
+		return nil, fmt.Errorf("no algorithms configured")
+	}
+	return algs, nil
+}
+
+/*
+parsePinnedThumbprints splits the comma-separated JWKS_PINNED_THUMBPRINTS value into a set.
+An empty set disables pinning.
+*/
+func parsePinnedThumbprints(raw string) map[string]struct{} {
+	pinned := make(map[string]struct{})
+	for _, tp := range strings.Split(raw, ",") {
+		tp = strings.TrimRight(strings.TrimSpace(tp), "=")
+		if tp != "" {
+			pinned[tp] = struct{}{}
+		}
+	}
+	return pinned
+}
+
+/*
+jwkThumbprint computes the RFC 7638 SHA-256 thumbprint of a key, base64url-encoded without
+padding. Only the required members are hashed, in lexicographic order.
+*/
+func jwkThumbprint(k jsonWebKey) (string, error) {
+	var canonical string
+	switch k.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Crv, k.X, k.Y)
+	default:
+		return "", fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+/*
+publicKey converts a JWK into an *rsa.PublicKey or *ecdsa.PublicKey.
+*/
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %v", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %v", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %v", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %v", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+/*
+fetchKeys downloads the JWKS and replaces the cached key set. Encryption keys, unsupported
+key types, and keys whose thumbprint is not pinned (when pinning is enabled) are skipped.
+*/
+func (v *jwksVerifier) fetchKeys() error {
+	resp, err := v.httpClient.Get(v.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("invalid JWKS document: %v", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if len(v.pinned) > 0 {
+			tp, err := jwkThumbprint(k)
+			if err != nil {
+				continue
+			}
+			if _, ok := v.pinned[tp]; !ok {
+				logWarn("rejecting unpinned JWKS key", "kid", k.Kid, "thumbprint", tp)
+				continue
+			}
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			logWarn("skipping JWKS key", "kid", k.Kid, "error", err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+	return nil
+}
+
+/*
+key returns the public key for kid, refreshing the key set when it is stale or the kid is
+unknown (e.g. after a Keycloak key rotation). Refreshes on unknown kids are limited to one
+per 30 seconds so forged kids cannot hammer the JWKS endpoint.
+*/
+func (v *jwksVerifier) key(kid string) (interface{}, error) {
+	v.mu.RLock()
+	pub, ok := v.keys[kid]
+	age := time.Since(v.fetchedAt)
+	v.mu.RUnlock()
+
+	if ok && age < v.refresh {
+		return pub, nil
+	}
+	if ok || age > 30*time.Second {
+		if err := v.fetchKeys(); err != nil {
+			logWarn("JWKS refresh failed", "url", v.url, "error", err)
+		}
+		v.mu.RLock()
+		pub, ok = v.keys[kid]
+		v.mu.RUnlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return pub, nil
+}
+
+/*
+parse verifies the token signature and standard time claims, and the issuer when
+KEYCLOAK_ISSUER is configured. The token's alg must be in the configured allowlist,
+checked before any key lookup, and must also match the key type; together these rule
+out "none" and HMAC tokens.
+*/
+func (v *jwksVerifier) parse(tokenString string) (*jwt.Token, error) {
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		pub, err := v.key(kid)
+		if err != nil {
+			return nil, err
+		}
+		switch pub.(type) {
+		case *rsa.PublicKey:
+			if !isRSAMethod(t.Method) {
+				return nil, fmt.Errorf("unexpected signing method %s for RSA key", t.Method.Alg())
+			}
+		case *ecdsa.PublicKey:
+			if _, ok := t.Method.(*jwt.SigningMethodECDSA); !ok {
+				return nil, fmt.Errorf("unexpected signing method %s for EC key", t.Method.Alg())
+			}
+		}
+		return pub, nil
+	}, jwt.WithValidMethods(v.algs))
+	if err != nil {
+		return nil, err
+	}
+	if v.issuer != "" {
+		if claims, ok := token.Claims.(jwt.MapClaims); !ok || !claims.VerifyIssuer(v.issuer, true) {
+			return nil, fmt.Errorf("unexpected token issuer")
+		}
+	}
+	return token, nil
+}
+
+/*
+isRSAMethod reports whether m verifies with an RSA public key (PKCS#1 v1.5 or PSS).
+*/
+func isRSAMethod(m jwt.SigningMethod) bool {
+	switch m.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+// jwks_test.go
+//
+// Tests for JWT_VERIFY_MODE=jwks signature verification, against test keys served from an
+// in-process JWKS endpoint.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/example/fiber-demo/testutil"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+/*
+newTestVerifier serves key's JWKS from a test server and returns a verifier for it that
+accepts the default algorithms.
+*/
+func newTestVerifier(t *testing.T, key *testutil.TestKey) *jwksVerifier {
+	t.Helper()
+	jwks, err := key.JWKS()
+	if err != nil {
+		t.Fatalf("JWKS: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jwks)
+	}))
+	t.Cleanup(srv.Close)
+	algs, err := parseAllowedAlgs("")
+	if err != nil {
+		t.Fatalf("parseAllowedAlgs: %v", err)
+	}
+	v := &jwksVerifier{
+		url:        srv.URL,
+		pinned:     map[string]struct{}{},
+		algs:       algs,
+		refresh:    time.Minute,
+		httpClient: srv.Client(),
+		keys:       make(map[string]interface{}),
+	}
+	if err := v.fetchKeys(); err != nil {
+		t.Fatalf("fetchKeys: %v", err)
+	}
+	return v
+}
+
+func newTestKey(t *testing.T, kid string) *testutil.TestKey {
+	t.Helper()
+	key, err := testutil.NewTestKey(kid)
+	if err != nil {
+		t.Fatalf("NewTestKey: %v", err)
+	}
+	return key
+}
+
+func TestJWKSVerifierAcceptsSignedToken(t *testing.T) {
+	key := newTestKey(t, "test-key")
+	v := newTestVerifier(t, key)
+	token, err := key.SignedToken(testutil.TokenSpec{Username: "alice", Roles: []string{"viewer"}})
+	if err != nil {
+		t.Fatalf("SignedToken: %v", err)
+	}
+	parsed, err := v.parse(token)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	ids, err := extractRoleIDs(parsed.Claims.(jwt.MapClaims))
+	if err != nil || len(ids) != 1 || ids[0] != "viewer" {
+		t.Fatalf("roles = %v, %v, want [viewer]", ids, err)
+	}
+}
+
+func TestJWKSVerifierRejectsForeignSignature(t *testing.T) {
+	v := newTestVerifier(t, newTestKey(t, "test-key"))
+	// Same kid, different private key: the signature does not verify.
+	forged, err := newTestKey(t, "test-key").SignedToken(testutil.TokenSpec{Username: "mallory"})
+	if err != nil {
+		t.Fatalf("SignedToken: %v", err)
+	}
+	if _, err := v.parse(forged); err == nil {
+		t.Fatal("parse accepted a token signed by a key outside the JWKS")
+	}
+}
+
+func TestJWKSVerifierRejectsExpiredToken(t *testing.T) {
+	key := newTestKey(t, "test-key")
+	v := newTestVerifier(t, key)
+	token, err := key.SignedToken(testutil.TokenSpec{Username: "alice", TTL: -time.Minute})
+	if err != nil {
+		t.Fatalf("SignedToken: %v", err)
+	}
+	if _, err := v.parse(token); err == nil {
+		t.Fatal("parse accepted an expired token")
+	}
+}
+
+func TestJWKSVerifierRejectsAlgNone(t *testing.T) {
+	key := newTestKey(t, "test-key")
+	v := newTestVerifier(t, key)
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"preferred_username": "mallory", "roles": []interface{}{"admin"},
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = key.Kid
+	unsigned, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	if _, err := v.parse(unsigned); err == nil {
+		t.Fatal(`parse accepted an alg "none" token`)
+	}
+}
+
+func TestJWKSVerifierRejectsHMACWithRSAKid(t *testing.T) {
+	key := newTestKey(t, "test-key")
+	v := newTestVerifier(t, key)
+	// The classic key confusion attack: HMAC-sign with the RSA public key as the secret.
+	secret, err := x509.MarshalPKIXPublicKey(&key.PrivateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"preferred_username": "mallory", "roles": []interface{}{"admin"},
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = key.Kid
+	signed, err := token.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: secret}))
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	if _, err := v.parse(signed); err == nil {
+		t.Fatal("parse accepted an HS256 token carrying an RSA kid")
+	}
+
+	// Even when HS256 gets past the allowlist, the RSA key refuses a non-RSA method.
+	v.algs = append(v.algs, "HS256")
+	if _, err := v.parse(signed); err == nil {
+		t.Fatal("parse accepted an HS256 token for an RSA key")
+	}
+}
+
+func TestParseAllowedAlgsRejectsNoneAndHMAC(t *testing.T) {
+	for _, raw := range []string{"none", "HS256", "RS256,HS512", "foo"} {
+		if _, err := parseAllowedAlgs(raw); err == nil {
+			t.Errorf("parseAllowedAlgs(%q) accepted a forbidden algorithm", raw)
+		}
+	}
+	if algs, err := parseAllowedAlgs("RS256, PS256 ,ES384"); err != nil || len(algs) != 3 {
+		t.Errorf("parseAllowedAlgs = %v, %v, want three algorithms", algs, err)
+	}
+}
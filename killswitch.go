@@ -0,0 +1,340 @@
+// killswitch.go
+//
+// Global path exclusions: a kill-switch that disables a resource for everyone, e.g. during a
+// security incident, without editing any role. Excluded patterns come from
+// GLOBAL_EXCLUDED_PATHS and the MongoDB "path_exclusions" collection, are mirrored in memory
+// (refreshed periodically), and are checked by Decide before any role is evaluated.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// exclusionAdminPath is the permission family of the endpoints managing exclusions. An
+// exclusion covering it is refused so the kill-switch can always be switched off again.
+const exclusionAdminPath = "admin:exclusions:*"
+
+// PathExclusion is a globally excluded permission path pattern. Source is "config" for
+// GLOBAL_EXCLUDED_PATHS entries, which cannot be removed at runtime, and "mongo" otherwise.
+type PathExclusion struct {
+	Path      string    `bson:"path" json:"path"`
+	Reason    string    `bson:"reason,omitempty" json:"reason,omitempty"`
+	CreatedBy string    `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	Source    string    `bson:"-" json:"source"`
+}
+
+// globalExclusionList is the in-memory view of the configured and stored exclusions.
+type globalExclusionList struct {
+	mu         sync.RWMutex
+	configured []PathExclusion
+	stored     []PathExclusion
+	reloadedAt time.Time
+}
+
+// globalExclusions is the active kill-switch list, loaded by initGlobalExclusions.
+var globalExclusions = &globalExclusionList{}
+
+/*
+initGlobalExclusions loads GLOBAL_EXCLUDED_PATHS (comma-separated permission path patterns)
+and the "path_exclusions" collection, then refreshes the stored entries every
+PATH_EXCLUSIONS_REFRESH (30s) so exclusions added on another instance apply here too.
+*/
+func initGlobalExclusions() {
+	refresh := 30 * time.Second
+	if v := os.Getenv("PATH_EXCLUSIONS_REFRESH"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid PATH_EXCLUSIONS_REFRESH %q: must be a positive duration such as 30s", v)
+		}
+		refresh = d
+	}
+	var configured []PathExclusion
+	for _, path := range strings.Split(os.Getenv("GLOBAL_EXCLUDED_PATHS"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if err := validateExclusionPath(path); err != nil {
+			log.Fatalf("Invalid GLOBAL_EXCLUDED_PATHS entry %q: %v", path, err)
+		}
+		configured = append(configured, PathExclusion{Path: path, Source: "config", CreatedAt: time.Now()})
+	}
+	globalExclusions.mu.Lock()
+	globalExclusions.configured = configured
+	globalExclusions.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := mongoDB.Collection("path_exclusions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "path", Value: 1}}, Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Fatal("Failed to create path_exclusions index:", err)
+	}
+	if err := globalExclusions.reload(); err != nil {
+		log.Fatal("Failed to load path exclusions:", err)
+	}
+	go func() {
+		for range time.Tick(refresh) {
+			if err := globalExclusions.reload(); err != nil {
+				logWarn("path exclusion refresh failed", "error", err)
+			}
+		}
+	}()
+	if all := globalExclusions.list(); len(all) > 0 {
+		logWarn("global path exclusions active", "count", len(all), "paths", exclusionPaths(all))
+	}
+}
+
+/*
+validateExclusionPath checks that path is a well-formed permission pattern that does not
+cover the exclusion admin endpoints.
+*/
+func validateExclusionPath(path string) error {
+	if err := validatePermissionPath(path); err != nil {
+		return err
+	}
+	if pathsOverlap(path, exclusionAdminPath) {
+		return fmt.Errorf("%q would also disable the %s endpoints", path, exclusionAdminPath)
+	}
+	return nil
+}
+
+/*
+reload replaces the stored exclusions with the current "path_exclusions" documents. Cached
+decisions are dropped when the set changed.
+*/
+func (g *globalExclusionList) reload() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cursor, err := mongoDB.Collection("path_exclusions").Find(ctx, bson.M{},
+		options.Find().SetSort(bson.D{{Key: "path", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	var docs []PathExclusion
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+	for i := range docs {
+		docs[i].Source = "mongo"
+	}
+	g.mu.Lock()
+	changed := exclusionPaths(g.stored) != exclusionPaths(docs)
+	g.stored = docs
+	g.reloadedAt = time.Now()
+	g.mu.Unlock()
+	if changed {
+		invalidateDecisions()
+	}
+	return nil
+}
+
+/*
+match returns the first exclusion whose pattern matches path.
+*/
+func (g *globalExclusionList) match(path string) (PathExclusion, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, set := range [][]PathExclusion{g.configured, g.stored} {
+		for _, ex := range set {
+			if matchPath(ex.Path, path) {
+				return ex, true
+			}
+		}
+	}
+	return PathExclusion{}, false
+}
+
+/*
+list returns every active exclusion, configured entries first.
+*/
+func (g *globalExclusionList) list() []PathExclusion {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return append(append([]PathExclusion{}, g.configured...), g.stored...)
+}
+
+/*
+add stores an exclusion in MongoDB and applies it locally right away.
+*/
+func (g *globalExclusionList) add(ctx context.Context, entry PathExclusion) error {
+	_, err := mongoDB.Collection("path_exclusions").ReplaceOne(ctx, bson.M{"path": entry.Path}, entry,
+		options.Replace().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	entry.Source = "mongo"
+	g.mu.Lock()
+	stored := make([]PathExclusion, 0, len(g.stored)+1)
+	for _, ex := range g.stored {
+		if ex.Path != entry.Path {
+			stored = append(stored, ex)
+		}
+	}
+	stored = append(stored, entry)
+	sort.Slice(stored, func(i, j int) bool { return stored[i].Path < stored[j].Path })
+	g.stored = stored
+	g.mu.Unlock()
+	invalidateDecisions()
+	return nil
+}
+
+/*
+remove deletes a stored exclusion from MongoDB and locally. It reports whether one existed.
+*/
+func (g *globalExclusionList) remove(ctx context.Context, path string) (bool, error) {
+	res, err := mongoDB.Collection("path_exclusions").DeleteOne(ctx, bson.M{"path": path})
+	if err != nil {
+		return false, err
+	}
+	g.mu.Lock()
+	stored := make([]PathExclusion, 0, len(g.stored))
+	for _, ex := range g.stored {
+		if ex.Path != path {
+			stored = append(stored, ex)
+		}
+	}
+	g.stored = stored
+	g.mu.Unlock()
+	invalidateDecisions()
+	return res.DeletedCount > 0, nil
+}
+
+/*
+isConfigured reports whether path is a GLOBAL_EXCLUDED_PATHS entry.
+*/
+func (g *globalExclusionList) isConfigured(path string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, ex := range g.configured {
+		if ex.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+exclusionPaths joins the patterns of the exclusions, for logs and change detection.
+*/
+func exclusionPaths(list []PathExclusion) string {
+	paths := make([]string, len(list))
+	for i, ex := range list {
+		paths[i] = ex.Path
+	}
+	return strings.Join(paths, ",")
+}
+
+/*
+listExclusionsHandler returns every active global exclusion.
+*/
+func listExclusionsHandler(c *fiber.Ctx) error {
+	globalExclusions.mu.RLock()
+	reloadedAt := globalExclusions.reloadedAt
+	globalExclusions.mu.RUnlock()
+	return c.JSON(ExclusionListResponse{
+		Exclusions: globalExclusions.list(),
+		ReloadedAt: timestamp(reloadedAt),
+	})
+}
+
+// addExclusionRequest is the body of POST /admin/exclusions.
+type addExclusionRequest struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+/*
+addExclusionHandler switches the kill-switch on for a path pattern.
+*/
+func addExclusionHandler(c *fiber.Ctx) error {
+	editor, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	var body addExclusionRequest
+	if err := decodeStrictJSON(c.Body(), &body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Invalid exclusion body",
+			"detail": err.Error(),
+		})
+	}
+	if err := validateExclusionPath(body.Path); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid path", "detail": err.Error()})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	entry := PathExclusion{Path: body.Path, Reason: body.Reason, CreatedBy: editor.ID, CreatedAt: time.Now().UTC()}
+	if err := globalExclusions.add(ctx, entry); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":  "Database write error",
+			"detail": err.Error(),
+		})
+	}
+	logWarn("global path exclusion enabled", "path", body.Path, "editor", logSafe(editor.ID), "reason", logSafe(body.Reason))
+	entry.Source = "mongo"
+	return c.Status(fiber.StatusCreated).JSON(entry)
+}
+
+/*
+removeExclusionHandler switches the kill-switch off for the pattern in ?path=. Configured
+exclusions can only be removed by changing GLOBAL_EXCLUDED_PATHS.
+*/
+func removeExclusionHandler(c *fiber.Ctx) error {
+	editor, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	path := c.Query("path")
+	if path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "path query parameter is required"})
+	}
+	if globalExclusions.isConfigured(path) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "exclusion is set by GLOBAL_EXCLUDED_PATHS"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	found, err := globalExclusions.remove(ctx, path)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":  "Database write error",
+			"detail": err.Error(),
+		})
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "no such exclusion"})
+	}
+	logInfo("global path exclusion removed", "path", logSafe(path), "editor", logSafe(editor.ID))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+/*
+reloadExclusionsHandler reloads the stored exclusions from MongoDB immediately, e.g. after
+editing the collection directly.
+*/
+func reloadExclusionsHandler(c *fiber.Ctx) error {
+	if err := globalExclusions.reload(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":  "Database read error",
+			"detail": err.Error(),
+		})
+	}
+	return listExclusionsHandler(c)
+}
@@ -0,0 +1,91 @@
+// killswitch_test.go
+//
+// Tests for global path exclusions.
+
+package main
+
+import "testing"
+
+/*
+useGlobalExclusions replaces the active exclusions for the duration of the test.
+*/
+func useGlobalExclusions(t *testing.T, configured, stored []PathExclusion) {
+	t.Helper()
+	previous := globalExclusions
+	globalExclusions = &globalExclusionList{configured: configured, stored: stored}
+	t.Cleanup(func() { globalExclusions = previous })
+}
+
+func TestGlobalExclusionOverridesAnyGrant(t *testing.T) {
+	useGlobalExclusions(t,
+		[]PathExclusion{{Path: "hr:payroll:**", Source: "config"}},
+		[]PathExclusion{{Path: "finance:*:export", Source: "mongo"}},
+	)
+	user := userFromRoles("root", []Role{{RoleID: "superuser", Permissions: []Permission{
+		{Path: "*:*:*", Regions: []string{"GLOBAL"}, Priority: 1000},
+		{Path: "**", Countries: []string{"*"}},
+	}}})
+	tests := []struct {
+		req     Requirement
+		allowed bool
+	}{
+		{Requirement{Path: "hr:payroll:view", Country: "GLOBAL"}, false},
+		{Requirement{Path: "hr:payroll:view", Country: "TH"}, false},
+		{Requirement{Path: "hr:payroll:bonus:approve", Country: "TH"}, false},
+		{Requirement{Path: "finance:ledger:export", Country: "GLOBAL"}, false},
+		{Requirement{Path: "hr:profile:view", Country: "GLOBAL"}, true},
+		{Requirement{Path: "finance:ledger:view", Country: "TH"}, true},
+	}
+	for _, tt := range tests {
+		d := Decide(user, tt.req)
+		if d.Allowed != tt.allowed {
+			t.Errorf("Decide(%s, %s) = %s, want allowed=%v", tt.req.Path, tt.req.Country, d, tt.allowed)
+		}
+		if !tt.allowed && d.Reason != decisionGloballyExcluded {
+			t.Errorf("Decide(%s, %s) reason = %s, want %s", tt.req.Path, tt.req.Country, d.Reason, decisionGloballyExcluded)
+		}
+	}
+}
+
+func TestGlobalExclusionReportsPattern(t *testing.T) {
+	useGlobalExclusions(t, []PathExclusion{{Path: "hr:payroll:**", Source: "config"}}, nil)
+	d := Decide(userFromRoles("alice", nil), Requirement{Path: "hr:payroll:view", Country: "TH"})
+	if d.DeniedBy == nil || d.String() != "globally_excluded by hr:payroll:**" {
+		t.Fatalf("Decide = %s, want globally_excluded by hr:payroll:**", d)
+	}
+}
+
+func TestValidateExclusionPathProtectsAdminEndpoints(t *testing.T) {
+	for _, path := range []string{"admin:exclusions:edit", "admin:**", "**"} {
+		if err := validateExclusionPath(path); err == nil {
+			t.Errorf("validateExclusionPath(%q) accepted a pattern covering the exclusion endpoints", path)
+		}
+	}
+	if err := validateExclusionPath("hr:payroll:**"); err != nil {
+		t.Errorf("validateExclusionPath(hr:payroll:**) = %v", err)
+	}
+}
+
+func TestAllowedCountriesForPathAppliesExclusionsAndSanctions(t *testing.T) {
+	useGlobalExclusions(t, []PathExclusion{{Path: "hr:payroll:**", Source: "config"}}, nil)
+	previous := restrictedCountries
+	restrictedCountries = map[string]struct{}{"KP": {}}
+	t.Cleanup(func() { restrictedCountries = previous })
+
+	user := userFromRoles("alice", []Role{{RoleID: "hr", Permissions: []Permission{
+		{Path: "hr:**", Countries: []string{"TH", "KP"}},
+	}}})
+	if got := AllowedCountriesForPath(user, "hr:payroll:view"); len(got) != 0 {
+		t.Errorf("AllowedCountriesForPath(excluded path) = %v, want none", got)
+	}
+	got := AllowedCountriesForPath(user, "hr:profile:view")
+	if len(got) != 1 || got[0] != "TH" {
+		t.Errorf("AllowedCountriesForPath(hr:profile:view) = %v, want [TH]", got)
+	}
+	for _, c := range []string{"TH", "KP"} {
+		want := c == "TH"
+		if allowed := IsAllowed(user, Requirement{Path: "hr:profile:view", Country: c}); allowed != want {
+			t.Errorf("IsAllowed(%s) = %v, want %v", c, allowed, want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// logging.go
+//
+// Leveled, structured logging. Lines are logfmt on the standard logger, e.g.
+//
+//	level=warn msg="skipping unknown role" user="alice" role="HR_VIEWER"
+//
+// so they can be filtered and parsed by key. LOG_LEVEL (error, warn, info, debug; default
+// info) drops less severe lines: production can run at warn, and LOG_LEVEL=debug adds every
+// permission decision when diagnosing a denial. Fatal startup errors are always logged.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// logLevel is a log severity; a lower value is more severe.
+type logLevel int
+
+const (
+	levelError logLevel = iota
+	levelWarn
+	levelInfo
+	levelDebug
+)
+
+// logLevelNames are the LOG_LEVEL values and the level= field of each level.
+var logLevelNames = []string{"error", "warn", "info", "debug"}
+
+// logThreshold is the least severe level that is logged. It is set once by initLogLevel.
+var logThreshold = levelInfo
+
+/*
+initLogLevel reads LOG_LEVEL. It runs first in main so every later line is filtered.
+*/
+func initLogLevel() {
+	v := strings.ToLower(os.Getenv("LOG_LEVEL"))
+	if v == "" {
+		return
+	}
+	for level, name := range logLevelNames {
+		if v == name {
+			logThreshold = logLevel(level)
+			return
+		}
+	}
+	log.Fatalf("Invalid LOG_LEVEL %q: expected error, warn, info, or debug", v)
+}
+
+/*
+logEnabled reports whether lines at level are logged, for callers that would otherwise
+build expensive fields for nothing.
+*/
+func logEnabled(level logLevel) bool {
+	return level <= logThreshold
+}
+
+/*
+logError, logWarn, logInfo, and logDebug log msg with key-value fields at their level, e.g.
+logWarn("skipping unknown role", "user", name, "role", roleID).
+*/
+func logError(msg string, fields ...interface{}) { logAt(levelError, msg, fields) }
+func logWarn(msg string, fields ...interface{})  { logAt(levelWarn, msg, fields) }
+func logInfo(msg string, fields ...interface{})  { logAt(levelInfo, msg, fields) }
+func logDebug(msg string, fields ...interface{}) { logAt(levelDebug, msg, fields) }
+
+/*
+logAt writes one logfmt line when level is enabled. A trailing field without a value is
+logged under the key "extra" rather than dropped.
+*/
+func logAt(level logLevel, msg string, fields []interface{}) {
+	if !logEnabled(level) {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("level=")
+	b.WriteString(logLevelNames[level])
+	b.WriteString(" msg=")
+	b.WriteString(strconv.Quote(msg))
+	for i := 0; i < len(fields); i += 2 {
+		key, value := fmt.Sprint(fields[i]), interface{}(nil)
+		if i+1 < len(fields) {
+			value = fields[i+1]
+		} else {
+			key, value = "extra", fields[i]
+		}
+		b.WriteByte(' ')
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(logValue(value))
+	}
+	// Skip logAt and the level helper so the standard logger's flags see the caller.
+	log.Output(3, b.String())
+}
+
+/*
+logValue renders a field value. Strings, errors, and Stringers (e.g. durations) are quoted
+so spaces and control characters never split a field; numbers and booleans are bare.
+*/
+func logValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case error:
+		return strconv.Quote(v.Error())
+	case fmt.Stringer:
+		return strconv.Quote(v.String())
+	case []string:
+		return strconv.Quote(strings.Join(v, ","))
+	case nil:
+		return `""`
+	}
+	return fmt.Sprint(v)
+}
@@ -8,13 +8,19 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
 	"github.com/golang-jwt/jwt/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -31,9 +37,10 @@ var (
 // ------------------------------------
 
 /*
-parseToken extracts the JWT token from the Authorization header
-and parses its claims without verifying the signature. This is safe because
-the signature has already been verified by the KrakenD API Gateway.
+parseToken extracts the JWT token from the Authorization header and parses its claims.
+In the default gateway mode the signature is not verified, which is safe because it has
+already been verified by the KrakenD API Gateway. With JWT_VERIFY_MODE=jwks the signature
+is verified locally against the Keycloak JWKS.
 */
 func parseToken(c *fiber.Ctx) (jwt.MapClaims, error) {
 	authHeader := c.Get("Authorization")
@@ -44,8 +51,21 @@ func parseToken(c *fiber.Ctx) (jwt.MapClaims, error) {
 	if len(parts) != 2 || parts[0] != "Bearer" {
 		return nil, fmt.Errorf("invalid Authorization header format")
 	}
-	tokenString := parts[1]
-	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+	return parseTokenString(parts[1])
+}
+
+/*
+parseTokenString parses a raw JWT the same way parseToken does for the Authorization
+header: verified against the JWKS in jwks mode, and rejected when its jti is revoked.
+*/
+func parseTokenString(tokenString string) (jwt.MapClaims, error) {
+	var token *jwt.Token
+	var err error
+	if tokenVerifier != nil {
+		token, err = tokenVerifier.parse(tokenString)
+	} else {
+		token, _, err = new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %v", err)
 	}
@@ -53,306 +73,1551 @@ func parseToken(c *fiber.Ctx) (jwt.MapClaims, error) {
 	if !ok {
 		return nil, fmt.Errorf("invalid token claims")
 	}
-	return claims, nil
+	if revokedTokens != nil {
+		if jti, _ := claims["jti"].(string); jti != "" && revokedTokens.isRevoked(jti) {
+			return nil, fmt.Errorf("token has been revoked")
+		}
+	}
+	return claims, nil
+}
+
+/*
+requestClaims returns the claims already parsed by authGate, or parses the Authorization
+header when the gate has not run for this request.
+*/
+func requestClaims(c *fiber.Ctx) (jwt.MapClaims, error) {
+	if claims, ok := c.Locals("claims").(jwt.MapClaims); ok {
+		return claims, nil
+	}
+	return parseToken(c)
+}
+
+// ------------------------------------
+// RBAC Types
+// ------------------------------------
+
+// Requirement defines a required permission path and country for an endpoint.
+// MinAcr and RequiredAmr optionally demand step-up authentication (e.g. MFA).
+// OwnerParam names the route parameter holding the resource owner's id; the middleware
+// resolves it into Owner so that SelfOnly permissions can be evaluated.
+// Audience names the target service for audience-scoped permissions (default SERVICE_AUDIENCE).
+// MaxTokenAge, when positive, demands that the caller authenticated at most that long ago.
+type Requirement struct {
+	Path        string
+	Country     string
+	MinAcr      string
+	RequiredAmr []string
+	MaxTokenAge time.Duration
+	OwnerParam  string
+	Owner       string
+	Audience    string
+	// Feature names a feature flag that must also be on for the caller (see flags.go).
+	Feature string
+	// DenyMessage, when set, replaces the generic message of a 403 permission denial. The
+	// code stays PERMISSION_DENIED, so clients never need to parse the text.
+	DenyMessage string
+	// RequiredRoles are role ids the token's roles claim must all carry, checked in addition
+	// to the permission. A missing one denies with code ROLE_REQUIRED.
+	RequiredRoles []string
+	// Attributes are the request attributes that permission Conditions are tested against.
+	// AttributesFrom, when set, lets requirePermission collect them from the request.
+	Attributes     map[string]interface{}
+	AttributesFrom func(c *fiber.Ctx) map[string]interface{}
+}
+
+// CountryFromToken is a Requirement.Country sentinel meaning "use the caller's own country
+// from the token's country claim" as the target country.
+const CountryFromToken = "FROM_TOKEN"
+
+// CountryParamPrefix marks a Requirement.Country read from a route parameter: "param:country"
+// takes the target country from c.Params("country"), uppercased and validated.
+const CountryParamPrefix = "param:"
+
+// Permission represents a single RBAC rule stored in MongoDB for a role.
+// Description is a human-readable summary for introspection; it never affects decisions.
+type Permission struct {
+	Path            string      `bson:"path" json:"path"`
+	Description     string      `bson:"description,omitempty" json:"description,omitempty"`
+	Regions         []string    `bson:"regions" json:"regions,omitempty"`
+	Countries       []string    `bson:"countries" json:"countries,omitempty"`
+	ExceptRegions   []string    `bson:"except_regions" json:"except_regions,omitempty"`
+	ExceptCountries []string    `bson:"except_countries" json:"except_countries,omitempty"`
+	ExceptPaths     []string    `bson:"except_paths" json:"except_paths,omitempty"`
+	SelfOnly        bool        `bson:"self_only" json:"self_only,omitempty"`
+	Priority        int         `bson:"priority" json:"priority,omitempty"`
+	Conditions      []Condition `bson:"conditions" json:"conditions,omitempty"`
+	Audiences       []string    `bson:"audiences" json:"audiences,omitempty"`
+}
+
+// Role represents a user role containing a list of permissions.
+// A disabled role is kept for audit purposes but grants nothing.
+type Role struct {
+	RoleID      string       `bson:"role_id" json:"role_id"`
+	Description string       `bson:"description,omitempty" json:"description,omitempty"`
+	Disabled    bool         `bson:"disabled" json:"disabled,omitempty"`
+	Permissions []Permission `bson:"permissions" json:"permissions"`
+
+	// countries is the role's expanded country set, computed once when the role is loaded
+	// so building a user only unions precomputed sets. Nil for roles built in memory.
+	countries map[string]struct{}
+}
+
+// User is a temporary struct representing the authenticated user,
+// compiled with their roles and all countries they are permitted to access.
+type User struct {
+	ID               string
+	Subject          string
+	AllowedCountries []string
+	Roles            []Role
+	// Audiences are the token's aud values, used to scope audience-tagged permissions.
+	Audiences []string
+
+	// addedCountries and removedCountries come from the user's user_overrides document:
+	// additions extend the country scope of every grant, removals deny and always win.
+	addedCountries   []string
+	removedCountries []string
+}
+
+// ------------------------------------
+// RBAC Implementation
+// ------------------------------------
+
+var (
+	// pathCaseSensitive makes matchPath compare segments exactly instead of case-insensitively.
+	pathCaseSensitive bool
+	// pathSeparator splits permission paths into segments, e.g. ":" in "hr:profile:view".
+	pathSeparator = ":"
+	// explicitCountryBeatsRegionExclusion lets a country listed in Countries override the
+	// permission's ExceptRegions (EXCLUSION_PRECEDENCE=specific). ExceptCountries always wins.
+	explicitCountryBeatsRegionExclusion bool
+)
+
+/*
+initPathMatching loads the path matching mode. RBAC_PATH_CASE_SENSITIVE=true makes
+"HR:Profile:View" and "hr:profile:view" distinct paths; the default is case-insensitive.
+RBAC_PATH_SEPARATOR changes the segment separator, e.g. "/" for "hr/profile/view".
+*/
+func initPathMatching() {
+	if v := os.Getenv("RBAC_PATH_SEPARATOR"); v != "" {
+		if strings.Contains(v, "*") {
+			log.Fatalf("Invalid RBAC_PATH_SEPARATOR %q: must not contain the wildcard character", v)
+		}
+		pathSeparator = v
+	}
+	if v := os.Getenv("RBAC_PATH_CASE_SENSITIVE"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid RBAC_PATH_CASE_SENSITIVE %q: expected true or false", v)
+		}
+		pathCaseSensitive = b
+	}
+}
+
+/*
+initExclusionPrecedence loads EXCLUSION_PRECEDENCE. "strict" (the default) means any
+exclusion wins, so a country listed in Countries is still denied when it lies in one of the
+permission's ExceptRegions. "specific" lets the more specific rule win: an explicitly listed
+country beats a region exclusion, while ExceptCountries still beats everything.
+*/
+func initExclusionPrecedence() {
+	switch v := os.Getenv("EXCLUSION_PRECEDENCE"); v {
+	case "", "strict":
+		explicitCountryBeatsRegionExclusion = false
+	case "specific":
+		explicitCountryBeatsRegionExclusion = true
+		logInfo("exclusion precedence: explicit countries override excluded regions")
+	default:
+		log.Fatalf("Invalid EXCLUSION_PRECEDENCE %q: expected strict or specific", v)
+	}
+}
+
+/*
+segmentEqual compares two path segments according to the configured case sensitivity.
+*/
+func segmentEqual(a, b string) bool {
+	if pathCaseSensitive {
+		return a == b
+	}
+	return strings.EqualFold(a, b)
+}
+
+/*
+segmentMatch reports whether a pattern segment matches a concrete segment. A "*" inside
+the segment matches any run of characters within it, so "report_2024_*", "*_q1", and
+"report_*_q1" all match "report_2024_q1", and a lone "*" matches any segment. Matching
+never crosses a separator and follows the configured case sensitivity.
+*/
+func segmentMatch(pattern, segment string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if !strings.Contains(pattern, "*") {
+		return segmentEqual(pattern, segment)
+	}
+	if !pathCaseSensitive {
+		pattern, segment = strings.ToLower(pattern), strings.ToLower(segment)
+	}
+	parts := strings.Split(pattern, "*")
+	// The text before the first "*" and after the last one are anchored; the parts in
+	// between are matched leftmost in order, which is sufficient for "*"-only globs.
+	first, last := parts[0], parts[len(parts)-1]
+	if len(segment) < len(first)+len(last) || !strings.HasPrefix(segment, first) || !strings.HasSuffix(segment, last) {
+		return false
+	}
+	middle := segment[len(first) : len(segment)-len(last)]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(middle, part)
+		if i < 0 {
+			return false
+		}
+		middle = middle[i+len(part):]
+	}
+	return true
+}
+
+/*
+segmentsOverlap reports whether two pattern segments can match a common segment. Two
+in-segment wildcards overlap exactly when their literal prefixes are compatible (one
+starts with the other) and so are their literal suffixes; the text in between can always
+be absorbed by the other side's "*".
+*/
+func segmentsOverlap(a, b string) bool {
+	aWild, bWild := strings.Contains(a, "*"), strings.Contains(b, "*")
+	switch {
+	case !aWild && !bWild:
+		return segmentEqual(a, b)
+	case !bWild:
+		return segmentMatch(a, b)
+	case !aWild:
+		return segmentMatch(b, a)
+	}
+	if !pathCaseSensitive {
+		a, b = strings.ToLower(a), strings.ToLower(b)
+	}
+	aPrefix, bPrefix := a[:strings.Index(a, "*")], b[:strings.Index(b, "*")]
+	aSuffix, bSuffix := a[strings.LastIndex(a, "*")+1:], b[strings.LastIndex(b, "*")+1:]
+	return (strings.HasPrefix(aPrefix, bPrefix) || strings.HasPrefix(bPrefix, aPrefix)) &&
+		(strings.HasSuffix(aSuffix, bSuffix) || strings.HasSuffix(bSuffix, aSuffix))
+}
+
+/*
+matchPath compares a permission path pattern (e.g., "hr:profile:*")
+against a target request path (e.g., "hr:profile:view") using wildcard matching.
+"*" matches exactly one segment and "**" matches zero or more segments, so "hr:**"
+covers the whole hr subtree. A "*" within a segment matches part of it, e.g.
+"reports:report_2024_*" matches "reports:report_2024_q1". It is used for both grants and
+ExceptPaths, so the wildcards and the case mode apply to both.
+*/
+func matchPath(pattern, target string) bool {
+	return matchSegments(strings.Split(pattern, pathSeparator), strings.Split(target, pathSeparator))
+}
+
+/*
+matchSegments is the recursive segment matcher behind matchPath.
+*/
+func matchSegments(p, t []string) bool {
+	for len(p) > 0 {
+		if p[0] == "**" {
+			if len(p) == 1 {
+				return true
+			}
+			for i := 0; i <= len(t); i++ {
+				if matchSegments(p[1:], t[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(t) == 0 {
+			return false
+		}
+		if !segmentMatch(p[0], t[0]) {
+			return false
+		}
+		p, t = p[1:], t[1:]
+	}
+	return len(t) == 0
+}
+
+/*
+pathsOverlap reports whether two path patterns can match at least one common concrete
+path. For example "hr:*" overlaps "hr:profile" and "*:profile" but not "hr:payroll:view",
+while "hr:**" overlaps all three. It is the pattern-vs-pattern counterpart of matchPath.
+*/
+func pathsOverlap(a, b string) bool {
+	return overlapSegments(strings.Split(a, pathSeparator), strings.Split(b, pathSeparator))
+}
+
+/*
+overlapSegments is the recursive segment intersector behind pathsOverlap. A "**" on either
+side may absorb zero segments or the other side's next segment; "*" overlaps any single segment,
+and in-segment wildcards are intersected by segmentsOverlap.
+*/
+func overlapSegments(p, q []string) bool {
+	if len(p) > 0 && p[0] == "**" {
+		return overlapSegments(p[1:], q) || (len(q) > 0 && overlapSegments(p, q[1:]))
+	}
+	if len(q) > 0 && q[0] == "**" {
+		return overlapSegments(p, q[1:]) || (len(p) > 0 && overlapSegments(p[1:], q))
+	}
+	if len(p) == 0 || len(q) == 0 {
+		return len(p) == 0 && len(q) == 0
+	}
+	if !segmentsOverlap(p[0], q[0]) {
+		return false
+	}
+	return overlapSegments(p[1:], q[1:])
+}
+
+/*
+contains checks if a target string exists in a list of strings,
+with case-insensitivity and support for the wildcard character '*'.
+*/
+func contains(list []string, target string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, target) || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+containsExact reports whether list holds target, case-insensitively and without treating
+"*" as a wildcard.
+*/
+func containsExact(list []string, target string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, target) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+matchesCountry is contains for a normalized country code: entries are normalized before
+comparing, so a role listing "UK" matches "GB", and "*" matches every country.
+*/
+func matchesCountry(list []string, country string) bool {
+	return containsExact(list, "*") || listsCountry(list, country)
+}
+
+/*
+listsCountry reports whether list names the normalized country code, without treating "*"
+as a wildcard.
+*/
+func listsCountry(list []string, country string) bool {
+	for _, v := range list {
+		if normalizeCountry(v) == country {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+isCountryPermitted evaluates if a specific country is allowed by a permission rule,
+taking into account included/excluded countries and regions. The precedence is, from
+strongest to weakest:
+
+ 1. ExceptCountries: a country listed in both Countries and ExceptCountries is denied.
+ 2. ExceptRegions, under EXCLUSION_PRECEDENCE=strict (the default): any exclusion wins.
+ 3. Countries, listed explicitly (not via "*").
+ 4. ExceptRegions, under EXCLUSION_PRECEDENCE=specific.
+ 5. Regions and the "*" wildcard.
+
+Countries: ["*"] means any single country: like Regions: ["GLOBAL"] it grants every country
+and is narrowed by ExceptCountries and ExceptRegions, but only a GLOBAL region satisfies a
+"GLOBAL" requirement.
+*/
+func isCountryPermitted(country string, perm Permission) bool {
+	if excludesCountry(perm, country) {
+		return false
+	}
+	if country != "GLOBAL" && matchesCountry(perm.Countries, country) {
+		return true
+	}
+	if grantsGlobalRegion(perm) {
+		return true
+	}
+	for _, region := range perm.Regions {
+		if countries, ok := regionMap()[region]; ok {
+			if contains(countries, country) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+excludesCountry reports whether perm's ExceptCountries or ExceptRegions deny country, with
+the same EXCLUSION_PRECEDENCE handling as isCountryPermitted. User override additions widen
+a grant's countries but never get past these exclusions.
+*/
+func excludesCountry(perm Permission, country string) bool {
+	if matchesCountry(perm.ExceptCountries, country) {
+		return true
+	}
+	if explicitCountryBeatsRegionExclusion && listsCountry(perm.Countries, country) {
+		return false
+	}
+	for _, exRegion := range perm.ExceptRegions {
+		if countries, ok := regionMap()[exRegion]; ok {
+			if contains(countries, country) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+grantsGlobalRegion reports whether perm lists the GLOBAL (or "*") region, the only grant that
+satisfies a "GLOBAL" requirement.
+*/
+func grantsGlobalRegion(perm Permission) bool {
+	for _, region := range perm.Regions {
+		if region == "*" || region == "GLOBAL" {
+			return true
+		}
+	}
+	return false
+}
+
+// Decision reasons reported by Decide.
+const (
+	decisionAllowed              = "allowed"
+	decisionCountryNotAllowed    = "country_not_allowed"
+	decisionPathExcluded         = "path_excluded"
+	decisionNoMatchingPermission = "no_matching_permission"
+	decisionGloballyExcluded     = "globally_excluded"
+	decisionLegallyRestricted    = "legally_restricted"
+)
+
+// Decision is the outcome of Decide. MatchedPermission is the winning allow rule of an
+// allowed decision and DeniedBy the winning ExceptPaths rule of a path_excluded denial;
+// both are copies, so callers may keep them. RoleID names the role the deciding rule
+// belongs to.
+type Decision struct {
+	Allowed           bool
+	Reason            string
+	MatchedPermission *Permission
+	DeniedBy          *Permission
+	RoleID            string
+}
+
+/*
+IsAllowed is the core RBAC logic function. It checks if a user has permission
+to access a resource based on their roles and the endpoint's requirements.
+It is a thin wrapper around Decide for callers that only need the verdict.
+*/
+func IsAllowed(user *User, req Requirement) bool {
+	return Decide(user, req).Allowed
+}
+
+/*
+Decide evaluates the requirement for the user and reports the verdict with its reason.
+
+Every permission whose ExceptPaths match the path is a deny candidate, and every other
+permission whose path and country match is an allow candidate. The candidate with the
+highest Priority decides; on a tie deny wins. With the default priority of 0 this means
+any matching exclusion denies, regardless of role or permission order. Permissions scoped to
+other audiences are ignored.
+*/
+func Decide(user *User, req Requirement) Decision {
+	// A global exclusion is a kill-switch: it denies before any role, even a GLOBAL grant, is considered.
+	if ex, ok := globalExclusions.match(req.Path); ok {
+		return Decision{Reason: decisionGloballyExcluded, DeniedBy: &Permission{Path: ex.Path}}
+	}
+	// Aliases such as UK are compared by their ISO-2 code from here on.
+	req.Country = normalizeCountry(req.Country)
+	// Legally restricted countries are denied for everyone, whatever the roles grant.
+	if isLegallyRestricted(req.Country) {
+		return Decision{Reason: decisionLegallyRestricted}
+	}
+	// First, check if the required country is in the user's pre-calculated list of allowed countries.
+	// GLOBAL requirements skip the scan entirely since only global grants can satisfy them.
+	if req.Country != "GLOBAL" && !contains(user.AllowedCountries, req.Country) {
+		return Decision{Reason: decisionCountryNotAllowed}
+	}
+	// Countries removed by a user override are denied even when a role grants them.
+	if req.Country != "GLOBAL" && containsExact(user.removedCountries, req.Country) {
+		return Decision{Reason: decisionCountryNotAllowed}
+	}
+	global := req.Country == "GLOBAL"
+	added := !global && containsExact(user.addedCountries, req.Country)
+
+	// Then, find the highest-priority rule among the user's roles that decides this path and country.
+	audience := targetAudience(req)
+	var decision ruleDecision
+	for _, role := range user.Roles {
+		for _, perm := range role.Permissions {
+			if !appliesToAudience(perm, user, audience) {
+				continue
+			}
+			// Explicit path exclusions are deny rules at the permission's priority.
+			if excludesPath(perm, req.Path) {
+				decision.consider(role.RoleID, perm, true)
+				continue
+			}
+			// A self-only rule applies solely to resources owned by the caller.
+			if perm.SelfOnly && (req.Owner == "" || req.Owner != user.Subject) {
+				continue
+			}
+			// Only a GLOBAL region grant can allow a GLOBAL requirement, so every other rule is
+			// skipped without matching its path.
+			if global && !grantsGlobalRegion(perm) {
+				continue
+			}
+			// The rule allows access if the path, country, and attribute conditions are permitted by it.
+			// An added country stands in for the rule's includes, never for its exclusions.
+			if matchPath(perm.Path, req.Path) && (isCountryPermitted(req.Country, perm) ||
+				added && !excludesCountry(perm, req.Country)) && conditionsMet(perm.Conditions, req.Attributes) {
+				decision.consider(role.RoleID, perm, false)
+			}
+		}
+	}
+	switch {
+	case !decision.found:
+		return Decision{Reason: decisionNoMatchingPermission}
+	case decision.deny:
+		return Decision{Reason: decisionPathExcluded, DeniedBy: &decision.rule, RoleID: decision.roleID}
+	default:
+		return Decision{Allowed: true, Reason: decisionAllowed, MatchedPermission: &decision.rule, RoleID: decision.roleID}
+	}
+}
+
+// ruleDecision tracks the winning rule, and the role it belongs to, while Decide scans the
+// user's permissions.
+type ruleDecision struct {
+	found    bool
+	priority int
+	deny     bool
+	rule     Permission
+	roleID   string
+}
+
+/*
+consider records a matching allow or deny rule. A higher priority replaces the current
+decision, and a deny at the same priority overrides an allow; otherwise the first rule
+seen at a priority is kept.
+*/
+func (d *ruleDecision) consider(roleID string, perm Permission, deny bool) {
+	switch {
+	case !d.found || perm.Priority > d.priority:
+		d.found, d.priority, d.deny, d.rule, d.roleID = true, perm.Priority, deny, perm, roleID
+	case perm.Priority == d.priority && deny && !d.deny:
+		d.deny, d.rule, d.roleID = true, perm, roleID
+	}
+}
+
+// DenialError is a denied Decision as an error, for callers that propagate denials as
+// errors; errors.As recovers the decision with its reason and deciding rule.
+type DenialError struct {
+	Decision Decision
+}
+
+func (e *DenialError) Error() string {
+	return "permission denied: " + e.Decision.String()
+}
+
+/*
+Err returns nil for an allowed decision and a *DenialError for a denied one.
+*/
+func (d Decision) Err() error {
+	if d.Allowed {
+		return nil
+	}
+	return &DenialError{Decision: d}
+}
+
+/*
+String renders the decision reason with the deciding rule for logs, e.g.
+"path_excluded by hr:* (role HR_VIEWER)".
+*/
+func (d Decision) String() string {
+	switch {
+	case d.Reason == decisionGloballyExcluded:
+		return d.Reason + " by " + d.DeniedBy.Path
+	case d.DeniedBy != nil:
+		return d.Reason + " by " + d.DeniedBy.Path + " (role " + d.RoleID + ")"
+	case d.MatchedPermission != nil:
+		return d.Reason + " by " + d.MatchedPermission.Path + " (role " + d.RoleID + ")"
+	}
+	return d.Reason
+}
+
+// Decision counters by reason, for the enforcing middleware and the PDP.
+var (
+	decisionsAllowed          atomic.Uint64
+	decisionsCountryDenied    atomic.Uint64
+	decisionsPathExcluded     atomic.Uint64
+	decisionsNoMatchingGrant  atomic.Uint64
+	decisionsGloballyExcluded atomic.Uint64
+	decisionsRestricted       atomic.Uint64
+)
+
+/*
+initDecisionMetrics registers the decision counters.
+*/
+func initDecisionMetrics() {
+	registerCounter("rbac_decisions_allowed_total", "Permission checks allowed by a role.", decisionsAllowed.Load)
+	registerCounter("rbac_decisions_denied_country_total", "Permission checks denied because no role grants the country.", decisionsCountryDenied.Load)
+	registerCounter("rbac_decisions_denied_excluded_total", "Permission checks denied by an except_paths rule.", decisionsPathExcluded.Load)
+	registerCounter("rbac_decisions_denied_no_match_total", "Permission checks denied because no permission matches.", decisionsNoMatchingGrant.Load)
+	registerCounter("rbac_decisions_denied_global_exclusion_total", "Permission checks denied by a global path exclusion.", decisionsGloballyExcluded.Load)
+	registerCounter("rbac_decisions_denied_legal_total", "Permission checks denied because the country is legally restricted.", decisionsRestricted.Load)
+}
+
+/*
+recordDecision counts a decision by its reason. Denials by a global exclusion are also
+logged as a warning, so operators see that a kill-switch is active.
+*/
+func recordDecision(d Decision) {
+	switch d.Reason {
+	case decisionAllowed:
+		decisionsAllowed.Add(1)
+	case decisionCountryNotAllowed:
+		decisionsCountryDenied.Add(1)
+	case decisionPathExcluded:
+		decisionsPathExcluded.Add(1)
+	case decisionNoMatchingPermission:
+		decisionsNoMatchingGrant.Add(1)
+	case decisionGloballyExcluded:
+		decisionsGloballyExcluded.Add(1)
+		logWarn("request denied by global path exclusion (kill-switch active)", "path", d.DeniedBy.Path)
+	case decisionLegallyRestricted:
+		decisionsRestricted.Add(1)
+	}
+}
+
+/*
+excludesPath reports whether one of the permission's ExceptPaths matches path.
+*/
+func excludesPath(perm Permission, path string) bool {
+	for _, exPath := range perm.ExceptPaths {
+		if matchPath(exPath, path) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+effectiveCountries expands a permission's regions and countries into concrete ISO-2 codes
+and applies its country/region exclusions. It returns a sorted list; when the permission is
+global the included set is ["*"] and the expanded exclusions are returned separately,
+since they cannot be subtracted from a wildcard.
+*/
+func effectiveCountries(perm Permission) (countries []string, except []string) {
+	included := make(map[string]struct{})
+	for _, c := range perm.Countries {
+		included[normalizeCountry(c)] = struct{}{}
+	}
+	for _, r := range perm.Regions {
+		if r == "*" || r == "GLOBAL" {
+			included["*"] = struct{}{}
+			continue
+		}
+		for _, c := range regionMap()[r] {
+			included[c] = struct{}{}
+		}
+	}
+
+	excluded := make(map[string]struct{})
+	for _, r := range perm.ExceptRegions {
+		for _, c := range regionMap()[r] {
+			excluded[c] = struct{}{}
+		}
+	}
+	if explicitCountryBeatsRegionExclusion {
+		for _, c := range perm.Countries {
+			delete(excluded, normalizeCountry(c))
+		}
+	}
+	for _, c := range perm.ExceptCountries {
+		excluded[normalizeCountry(c)] = struct{}{}
+	}
+
+	if _, global := included["*"]; global {
+		for c := range excluded {
+			except = append(except, c)
+		}
+		sort.Strings(except)
+		return []string{"*"}, except
+	}
+	for c := range included {
+		if _, ok := excluded[c]; !ok {
+			countries = append(countries, c)
+		}
+	}
+	sort.Strings(countries)
+	return countries, nil
+}
+
+/*
+knownCountries returns every ISO-2 code that appears in the region map, sorted.
+It is the concrete expansion of a global ("*") grant.
+*/
+func knownCountries() []string {
+	set := make(map[string]struct{})
+	for _, countries := range regionMap() {
+		for _, c := range countries {
+			if c != "*" {
+				set[c] = struct{}{}
+			}
+		}
+	}
+	out := make([]string, 0, len(set))
+	for c := range set {
+		out = append(out, c)
+	}
+	sort.Strings(out)
+	return out
+}
+
+/*
+isKnownCountry reports whether code is an ISO-2 country present in the region map.
+*/
+func isKnownCountry(code string) bool {
+	_, ok := regionsForCountry(code)
+	return ok
+}
+
+/*
+AllowedCountriesForPath returns the sorted, deduplicated ISO-2 codes the user may access for
+a specific path. Only permissions whose path matches contribute, with regions expanded and
+exclusions applied; a global grant expands to the full known country set. Mirroring IsAllowed's
+priority resolution, only permissions ranked above every matching ExceptPaths rule contribute.
+Permissions with attribute conditions are left out, since no request attributes are known,
+and audience-scoped permissions only count for SERVICE_AUDIENCE. User override additions
+apply when a grant matching the path does not exclude them, and removals are always taken out. Like Decide, a
+globally excluded path yields no countries and legally restricted countries are never listed.
+*/
+func AllowedCountriesForPath(user *User, path string) []string {
+	if _, excluded := globalExclusions.match(path); excluded {
+		return []string{}
+	}
+	// ExceptPaths rules deny every country, so the strongest one sets the bar for grants.
+	var exclusion ruleDecision
+	for _, role := range user.Roles {
+		for _, perm := range role.Permissions {
+			if excludesPath(perm, path) && appliesToAudience(perm, user, serviceAudience) {
+				exclusion.consider(role.RoleID, perm, true)
+			}
+		}
+	}
+
+	set := make(map[string]struct{})
+	var grants []Permission
+	for _, role := range user.Roles {
+		for _, perm := range role.Permissions {
+			if excludesPath(perm, path) || !matchPath(perm.Path, path) || !appliesToAudience(perm, user, serviceAudience) {
+				continue
+			}
+			if exclusion.found && perm.Priority <= exclusion.priority {
+				continue
+			}
+			// Conditional grants depend on request attributes that are unknown here.
+			if len(perm.Conditions) > 0 {
+				continue
+			}
+			grants = append(grants, perm)
+			countries, except := effectiveCountries(perm)
+			if len(countries) == 1 && countries[0] == "*" {
+				excluded := make(map[string]struct{}, len(except))
+				for _, c := range except {
+					excluded[c] = struct{}{}
+				}
+				for _, c := range knownCountries() {
+					if _, ok := excluded[c]; !ok {
+						set[c] = struct{}{}
+					}
+				}
+				continue
+			}
+			for _, c := range countries {
+				set[c] = struct{}{}
+			}
+		}
+	}
+	// User overrides extend any grant for the path that does not exclude the added country;
+	// removals always win.
+	for _, c := range user.addedCountries {
+		for _, perm := range grants {
+			if !excludesCountry(perm, c) {
+				set[c] = struct{}{}
+				break
+			}
+		}
+	}
+	for _, c := range user.removedCountries {
+		delete(set, c)
+	}
+	out := make([]string, 0, len(set))
+	for c := range set {
+		if !isLegallyRestricted(c) {
+			out = append(out, c)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+/*
+FilterAllowedCountries returns the subset of requested countries the user may access for
+path, preserving the request order and dropping duplicates, so multi-country handlers can
+serve partial results instead of denying the whole request. Codes are matched
+case-insensitively and returned uppercased.
+*/
+func FilterAllowedCountries(user *User, path string, requested []string) []string {
+	allowed := []string{}
+	seen := make(map[string]struct{}, len(requested))
+	for _, country := range requested {
+		country = normalizeCountry(country)
+		if _, dup := seen[country]; dup || country == "" {
+			continue
+		}
+		seen[country] = struct{}{}
+		if IsAllowed(user, Requirement{Path: path, Country: country}) {
+			allowed = append(allowed, country)
+		}
+	}
+	return allowed
+}
+
+// AccessDifference is one path+country pair on which two users' decisions differ.
+type AccessDifference struct {
+	Path    string `json:"path"`
+	Country string `json:"country"`
+	A       bool   `json:"a"`
+	B       bool   `json:"b"`
+}
+
+/*
+DiffAccess evaluates IsAllowed for both users across every path x country pair and returns
+the pairs where the decisions differ, in input order. An empty result means the two users
+have identical access over the given matrix.
+*/
+func DiffAccess(a, b *User, paths []string, countries []string) []AccessDifference {
+	diffs := []AccessDifference{}
+	for _, path := range paths {
+		for _, country := range countries {
+			req := Requirement{Path: path, Country: country}
+			allowedA, allowedB := IsAllowed(a, req), IsAllowed(b, req)
+			if allowedA != allowedB {
+				diffs = append(diffs, AccessDifference{Path: path, Country: country, A: allowedA, B: allowedB})
+			}
+		}
+	}
+	return diffs
+}
+
+/*
+PermissionsMatchingPattern returns the user's permissions whose paths intersect the given
+pattern, answering discovery queries such as "what can I do under hr:*?" without listing
+every concrete path. ExceptPaths are not subtracted: a grant that overlaps the pattern is
+returned even if some paths under it are excluded.
+*/
+func PermissionsMatchingPattern(user *User, pattern string) []Permission {
+	var out []Permission
+	for _, role := range user.Roles {
+		for _, perm := range role.Permissions {
+			if pathsOverlap(perm.Path, pattern) {
+				out = append(out, perm)
+			}
+		}
+	}
+	return out
+}
+
+// ------------------------------------
+// JWT to User + Role Mapping
+// ------------------------------------
+
+// maxAllowedCountries caps the distinct countries materialized for one user (MAX_ALLOWED_COUNTRIES).
+var maxAllowedCountries = 1000
+
+// maxRolesPerUser caps the distinct role ids resolved for one token (MAX_ROLES_PER_USER).
+var maxRolesPerUser = 200
+
+// tooManyRolesCode is the denial code of a token carrying more than maxRolesPerUser roles.
+const tooManyRolesCode = "TOO_MANY_ROLES"
+
+// errTooManyRoles rejects a token whose roles exceed maxRolesPerUser before any is loaded.
+var errTooManyRoles = errors.New("permission check failed: token carries too many roles")
+
+/*
+initExpansionLimits loads the safeguards that bound per-request permission expansion.
+*/
+func initExpansionLimits() {
+	if v := os.Getenv("MAX_ALLOWED_COUNTRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid MAX_ALLOWED_COUNTRIES %q: must be a positive integer", v)
+		}
+		maxAllowedCountries = n
+	}
+	if v := os.Getenv("MAX_ROLES_PER_USER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid MAX_ROLES_PER_USER %q: must be a positive integer", v)
+		}
+		maxRolesPerUser = n
+	}
+}
+
+/*
+userErrorCode returns the denial code for an extractUser error, or "" when it has none.
+*/
+func userErrorCode(err error) string {
+	if errors.Is(err, errTooManyRoles) {
+		return tooManyRolesCode
+	}
+	return ""
+}
+
+// roleResolutionLenient skips roles missing from MongoDB instead of failing the request
+// (ROLE_RESOLUTION_MODE=lenient). Database errors still fail the request in both modes.
+var roleResolutionLenient bool
+
+/*
+initRoleResolutionMode loads ROLE_RESOLUTION_MODE, strict (default) or lenient.
+*/
+func initRoleResolutionMode() {
+	switch v := os.Getenv("ROLE_RESOLUTION_MODE"); v {
+	case "", "strict":
+		roleResolutionLenient = false
+	case "lenient":
+		roleResolutionLenient = true
+	default:
+		log.Fatalf("Invalid ROLE_RESOLUTION_MODE %q: expected strict or lenient", v)
+	}
+}
+
+/*
+extractUser parses JWT claims, retrieves the associated roles from MongoDB,
+and builds a User object with all permissions and a computed list of allowed countries.
+*/
+func extractUser(claims jwt.MapClaims) (*User, error) {
+	username, ok := claims["preferred_username"].(string)
+	if !ok {
+		return nil, fmt.Errorf("preferred_username missing or not a string in token")
+	}
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
+	// Decisions use the username as issued; logs only ever see the sanitized form.
+	logName := logSafe(username)
+	subject, _ := claims["sub"].(string)
+	roleIDs, err := extractRoleIDs(claims)
+	if err != nil {
+		return nil, err
+	}
+	// Checked before any role is loaded so a crafted token cannot fan out into MongoDB.
+	if len(roleIDs) > maxRolesPerUser {
+		logWarn("too many roles", "user", logName, "roles", len(roleIDs), "max", maxRolesPerUser)
+		return nil, errTooManyRoles
+	}
+	roleIDs, expired := unexpiredRoleIDs(claims, roleIDs)
+	for _, roleID := range expired {
+		logInfo("skipping expired role", "user", logName, "role", roleID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var roles []Role
+	countrySet := make(map[string]struct{})
+
+	for _, roleID := range roleIDs {
+		role, err := loadRole(ctx, roleID)
+		if err == mongo.ErrNoDocuments && roleResolutionLenient {
+			logWarn("skipping unknown role", "user", logName, "role", roleID)
+			continue
+		}
+		if err != nil {
+			// Log the actual error for debugging but return a generic message to the client.
+			logWarn("role lookup failed", "user", logName, "role", logSafe(roleID), "error", err)
+			return nil, fmt.Errorf("permission check failed: could not resolve user roles")
+		}
+		if role.Disabled {
+			logInfo("skipping disabled role", "user", logName, "role", roleID)
+			continue
+		}
+
+		// Calculate the set of all countries this user is allowed to access.
+		addRoleCountries(countrySet, role)
+		roles = append(roles, role)
+
+		// Guard against pathological role definitions materializing huge country sets.
+		if len(countrySet) > maxAllowedCountries {
+			logWarn("too many allowed countries", "user", logName, "max", maxAllowedCountries, "role", roleID)
+			return nil, fmt.Errorf("permission check failed: role configuration expands to too many countries")
+		}
+	}
+
+	user := &User{
+		ID:               username,
+		Subject:          subject,
+		AllowedCountries: countryList(countrySet),
+		Roles:            roles,
+		Audiences:        tokenAudiences(claims),
+	}
+	if err := finishUser(ctx, user, claims); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+/*
+finishUser applies what narrows or widens a user beyond their roles: the user's override
+document, the allowed-countries claim, and the empty-country check. Shadow evaluation runs
+it too, so candidate users differ from enforced ones only by their roles.
+*/
+func finishUser(ctx context.Context, user *User, claims jwt.MapClaims) error {
+	if userOverridesEnabled {
+		override, err := loadUserOverride(ctx, user.ID)
+		if err != nil {
+			logWarn("user override lookup failed", "user", logSafe(user.ID), "error", err)
+			return fmt.Errorf("permission check failed: could not resolve user overrides")
+		}
+		if override != nil {
+			applyUserOverride(user, override)
+		}
+	}
+	if err := applyCountriesClaim(user, claims); err != nil {
+		return err
+	}
+	return checkUserCountries(user)
+}
+
+/*
+countryList converts a country set into a slice, collapsing it to ["*"] when a global
+grant makes the individual countries irrelevant.
+*/
+func countryList(countrySet map[string]struct{}) []string {
+	if _, global := countrySet["*"]; global {
+		return []string{"*"}
+	}
+	countries := make([]string, 0, len(countrySet))
+	for c := range countrySet {
+		countries = append(countries, c)
+	}
+	return countries
+}
+
+/*
+addRoleCountries adds every country granted by the role to countrySet, using the role's
+precomputed set when it has one. Once a global grant is present nothing more is added,
+because "*" already covers every country.
+*/
+func addRoleCountries(countrySet map[string]struct{}, role Role) {
+	if _, global := countrySet["*"]; global {
+		return
+	}
+	countries := role.countries
+	if countries == nil {
+		countries = roleCountrySet(role)
+	}
+	if _, global := countries["*"]; global {
+		countrySet["*"] = struct{}{}
+		return
+	}
+	for c := range countries {
+		countrySet[c] = struct{}{}
+	}
+}
+
+/*
+roleCountrySet expands the role's permissions into the set of countries they grant,
+expanding regions and collapsing to {"*"} as soon as a global grant is found.
+*/
+func roleCountrySet(role Role) map[string]struct{} {
+	set := make(map[string]struct{})
+	regions := regionMap()
+	for _, perm := range role.Permissions {
+		for _, r := range perm.Regions {
+			if r == "GLOBAL" || r == "*" {
+				return map[string]struct{}{"*": {}}
+			}
+			for _, c := range regions[r] {
+				set[c] = struct{}{}
+			}
+		}
+		for _, c := range perm.Countries {
+			if c == "*" {
+				return map[string]struct{}{"*": {}}
+			}
+			set[normalizeCountry(c)] = struct{}{}
+		}
+	}
+	return set
+}
+
+/*
+userFromRoles builds a User from already-loaded roles, computing AllowedCountries the
+same way extractUser does. It is used to evaluate hypothetical role definitions. Disabled
+roles are left out entirely, as extractUser skips them.
+*/
+func userFromRoles(id string, roles []Role) *User {
+	countrySet := make(map[string]struct{})
+	var enabled []Role
+	for _, role := range roles {
+		if !role.Disabled {
+			addRoleCountries(countrySet, role)
+			enabled = append(enabled, role)
+		}
+	}
+	return &User{ID: id, AllowedCountries: countryList(countrySet), Roles: enabled}
+}
+
+/*
+loadRole returns the role document for roleID, serving it from the role cache
+when enabled and falling back to MongoDB on a miss.
+*/
+func loadRole(ctx context.Context, roleID string) (Role, error) {
+	if role, ok := roleCaches.get(roleID); ok {
+		return role, nil
+	}
+	var role Role
+	err := findRoleDocument(ctx, "roles", bson.M{"role_id": roleID}, &role)
+	if err != nil {
+		return Role{}, err
+	}
+	if err := checkLoadedRole(role); err != nil {
+		return Role{}, err
+	}
+	role.countries = roleCountrySet(role)
+	roleCaches.set(roleID, role)
+	return role, nil
 }
 
 // ------------------------------------
-// RBAC Types
+// Middleware
 // ------------------------------------
 
-// Requirement defines a required permission path and country for an endpoint.
-type Requirement struct {
-	Path    string
-	Country string
-}
-
-// Permission represents a single RBAC rule stored in MongoDB for a role.
-type Permission struct {
-	Path            string   `bson:"path"`
-	Regions         []string `bson:"regions"`
-	Countries       []string `bson:"countries"`
-	ExceptRegions   []string `bson:"except_regions"`
-	ExceptCountries []string `bson:"except_countries"`
-	ExceptPaths     []string `bson:"except_paths"`
-}
+// logDenials enables the warn-level denial log line (LOG_DENIALS, default true).
+var logDenials = true
 
-// Role represents a user role containing a list of permissions.
-type Role struct {
-	RoleID      string       `bson:"role_id"`
-	Permissions []Permission `bson:"permissions"`
+/*
+initDenialLogging loads LOG_DENIALS.
+*/
+func initDenialLogging() {
+	if v := os.Getenv("LOG_DENIALS"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid LOG_DENIALS %q: expected true or false", v)
+		}
+		logDenials = b
+	}
 }
 
-// User is a temporary struct representing the authenticated user,
-// compiled with their roles and all countries they are permitted to access.
-type User struct {
-	ID               string
-	AllowedCountries []string
-	Roles            []Role
+/*
+logDenial writes a structured warn-level line for a 403 so SOC teams can see who was
+denied what, and queues the same event for the denial webhook when one is configured.
+Only identifying claims are logged, never the raw token, and values are quoted so they
+cannot forge extra log lines.
+*/
+func logDenial(c *fiber.Ctx, claims jwt.MapClaims, req Requirement, reason string) {
+	sub, _ := claims["sub"].(string)
+	username, _ := claims["preferred_username"].(string)
+	sub, username = logSafe(sub), logSafe(username)
+	if webhook != nil {
+		webhook.enqueue(DenialEvent{
+			User:      username,
+			Subject:   sub,
+			Path:      req.Path,
+			Country:   req.Country,
+			Method:    c.Method(),
+			Route:     c.Path(),
+			IP:        clientIP(c),
+			Reason:    reason,
+			Timestamp: time.Now().UTC(),
+		})
+	}
+	if !logDenials {
+		return
+	}
+	logWarn("access denied", "sub", sub, "preferred_username", username, "path", req.Path, "country", req.Country,
+		"method", c.Method(), "route", c.Path(), "client_ip", clientIP(c), "reason", reason)
 }
 
-// ------------------------------------
-// RBAC Implementation
-// ------------------------------------
-
 /*
-matchPath compares a permission path pattern (e.g., "hr:profile:*")
-against a target request path (e.g., "hr:profile:view") using wildcard matching.
+validateRequirement checks that a route Requirement is satisfiable: the path must have no
+empty segments and the country must be GLOBAL, FROM_TOKEN, "param:<name>", or a known ISO-2 code.
 */
-func matchPath(pattern, target string) bool {
-	p := strings.Split(pattern, ":")
-	t := strings.Split(target, ":")
-	if len(p) != len(t) {
-		return false
+func validateRequirement(req Requirement) error {
+	if req.Path == "" {
+		return fmt.Errorf("path is empty")
 	}
-	for i := range p {
-		if p[i] != "*" && !strings.EqualFold(p[i], t[i]) {
-			return false
+	if req.MaxTokenAge < 0 {
+		return fmt.Errorf("max token age %s is negative", req.MaxTokenAge)
+	}
+	for _, role := range req.RequiredRoles {
+		if role == "" {
+			return fmt.Errorf("required roles contain an empty role")
 		}
 	}
-	return true
+	for _, segment := range strings.Split(req.Path, pathSeparator) {
+		if segment == "" {
+			return fmt.Errorf("path %q has an empty segment", req.Path)
+		}
+	}
+	switch req.Country {
+	case "GLOBAL", CountryFromToken:
+		return nil
+	case "":
+		return fmt.Errorf("country is empty")
+	}
+	if strings.HasPrefix(req.Country, CountryParamPrefix) {
+		if strings.TrimPrefix(req.Country, CountryParamPrefix) == "" {
+			return fmt.Errorf("country %q names no route parameter", req.Country)
+		}
+		return nil
+	}
+	if !isKnownCountry(req.Country) {
+		return fmt.Errorf("country %q is not a known country code", req.Country)
+	}
+	return nil
 }
 
+// denyResponseFormat selects how denials are rendered: "json" (default) or "text".
+var denyResponseFormat = "json"
+
 /*
-contains checks if a target string exists in a list of strings,
-with case-insensitivity and support for the wildcard character '*'.
+initDenyResponseFormat loads DENY_RESPONSE_FORMAT, which lets legacy clients that cannot
+parse JSON error bodies receive plain-text denials instead.
 */
-func contains(list []string, target string) bool {
-	for _, v := range list {
-		if strings.EqualFold(v, target) || v == "*" {
-			return true
-		}
+func initDenyResponseFormat() {
+	switch v := os.Getenv("DENY_RESPONSE_FORMAT"); v {
+	case "", "json":
+		denyResponseFormat = "json"
+	case "text":
+		denyResponseFormat = "text"
+	default:
+		log.Fatalf("Invalid DENY_RESPONSE_FORMAT %q: expected json or text", v)
 	}
-	return false
 }
 
 /*
-regionMap returns a static mapping of region codes (e.g., "ASIA")
-to their corresponding lists of ISO-2 country codes.
-*/
-func regionMap() map[string][]string {
-	return map[string][]string{
-		// Africa (all African countries)
-		"AFRICA": {
-			"DZ", "AO", "BJ", "BW", "BF", "BI", "CV", "CM", "CF", "TD", "KM", "CG", "CD", "CI",
-			"DJ", "EG", "GQ", "ER", "SZ", "ET", "GA", "GM", "GH", "GN", "GW", "KE", "LS", "LR",
-			"LY", "MG", "MW", "ML", "MR", "MU", "MA", "MZ", "NA", "NE", "NG", "RW", "ST", "SN",
-			"SC", "SL", "SO", "ZA", "SS", "SD", "TZ", "TG", "TN", "UG", "EH", "ZM", "ZW",
-		},
-		// Asia (all Asian countries, including Middle East)
-		"ASIA": {
-			"AF", "AM", "AZ", "BH", "BD", "BT", "BN", "KH", "CN", "CY", "GE", "IN", "ID", "IR",
-			"IQ", "IL", "JP", "JO", "KZ", "KW", "KG", "LA", "LB", "MY", "MV", "MN", "MM", "NP",
-			"KP", "OM", "PK", "PS", "PH", "QA", "RU", "SA", "SG", "KR", "LK", "SY", "TW", "TJ",
-			"TH", "TL", "TR", "TM", "AE", "UZ", "VN", "YE",
-		},
-		// Europe
-		"EUROPE": {
-			"AL", "AD", "AT", "BY", "BE", "BA", "BG", "HR", "CY", "CZ", "DK", "EE", "FI", "FR",
-			"DE", "GR", "HU", "IS", "IE", "IT", "LV", "LI", "LT", "LU", "MT", "MD", "MC", "ME",
-			"NL", "MK", "NO", "PL", "PT", "RO", "SM", "RS", "SK", "SI", "ES", "SE", "CH", "UA", "UK", "VA",
-		},
-		// North America
-		"NORTH_AMERICA": {
-			"AG", "BS", "BB", "BZ", "CA", "CR", "CU", "DM", "DO", "SV", "GD", "GT", "HT", "HN",
-			"JM", "MX", "NI", "PA", "KN", "LC", "VC", "TT", "US",
-		},
-		// South America
-		"SOUTH_AMERICA": {
-			"AR", "BO", "BR", "CL", "CO", "EC", "GY", "PY", "PE", "SR", "UY", "VE",
-		},
-		// Oceania
-		"OCEANIA": {
-			"AU", "FJ", "KI", "MH", "FM", "NR", "NZ", "PW", "PG", "WS", "SB", "TO", "TV", "VU",
-		},
-		// Antarctica
-		"ANTARCTICA": {"AQ"},
-		// Global wildcard for all countries
-		"GLOBAL": {"*"},
-	}
+deny writes an authentication/authorization failure. Regular requests get the usual
+{"error": ...} JSON body, or "403 Forbidden: <message>" text when DENY_RESPONSE_FORMAT=text;
+websocket upgrade requests get a bare status response, which is the proper way to reject
+the handshake.
+*/
+func deny(c *fiber.Ctx, status int, message string) error {
+	return denyWithCode(c, status, "", message)
 }
 
 /*
-isCountryPermitted evaluates if a specific country is allowed by a permission rule,
-taking into account included/excluded countries and regions.
+denyWithCode is deny with a stable machine-readable code (e.g. "STEP_UP_REQUIRED") that
+clients can act on, added to the JSON body as "code" and prefixed to text responses.
 */
-func isCountryPermitted(country string, perm Permission) bool {
-	if contains(perm.ExceptCountries, country) {
-		return false
+func denyWithCode(c *fiber.Ctx, status int, code, message string) error {
+	return denyWithDetails(c, status, code, message, nil)
+}
+
+/*
+denyWithDetails is denyWithCode with extra fields merged into the JSON body, e.g. the
+per-requirement breakdown of a composite check. Text and websocket denials omit them.
+*/
+func denyWithDetails(c *fiber.Ctx, status int, code, message string, details fiber.Map) error {
+	if isWebSocketUpgrade(c) {
+		return c.SendStatus(status)
 	}
-	for _, exRegion := range perm.ExceptRegions {
-		if countries, ok := regionMap()[exRegion]; ok {
-			if contains(countries, country) {
-				return false
-			}
+	if denyResponseFormat == "text" {
+		if code != "" {
+			message = code + ": " + message
 		}
+		return c.Status(status).SendString(fmt.Sprintf("%d %s: %s", status, utils.StatusMessage(status), message))
 	}
-	if contains(perm.Countries, country) {
-		return true
+	body := fiber.Map{"error": message}
+	if code != "" {
+		body["code"] = code
 	}
-	for _, region := range perm.Regions {
-		if region == "*" || region == "GLOBAL" {
-			return true
-		}
-		if countries, ok := regionMap()[region]; ok {
-			if contains(countries, country) {
-				return true
-			}
-		}
+	for k, v := range details {
+		body[k] = v
 	}
-	return false
+	return c.Status(status).JSON(body)
 }
 
+// DecisionHook is custom policy logic evaluated after IsAllowed. It receives the built-in
+// decision as base and may only restrict it: a false base is never turned into an allow.
+type DecisionHook func(c *fiber.Ctx, user *User, req Requirement, base bool) bool
+
+// decisionHook is the optional hook consulted by requirePermission. Nil means no custom logic.
+var decisionHook DecisionHook
+
 /*
-IsAllowed is the core RBAC logic function. It checks if a user has permission
-to access a resource based on their roles and the endpoint's requirements.
+SetDecisionHook installs a custom decision hook used by requirePermission, e.g. to deny
+on weekends or outside corporate IP ranges. Passing nil removes the hook.
 */
-func IsAllowed(user *User, req Requirement) bool {
-	// First, check if the required country is in the user's pre-calculated list of allowed countries.
-	if !contains(user.AllowedCountries, req.Country) && req.Country != "GLOBAL" {
-		return false
-	}
+func SetDecisionHook(hook DecisionHook) {
+	decisionHook = hook
+}
 
-	// Then, check if any of the user's roles grant permission for the required path and country.
-	for _, role := range user.Roles {
-		for _, perm := range role.Permissions {
-			// Check for explicit path exclusions first.
-			for _, exPath := range perm.ExceptPaths {
-				if matchPath(exPath, req.Path) {
-					return false // Deny if path is explicitly excluded.
-				}
+/*
+requirePermission returns a Fiber middleware. It parses the JWT, builds the user's
+permission profile from MongoDB, and denies access if the required permissions are not met.
+*/
+func requirePermission(req Requirement) fiber.Handler {
+	// A Requirement that can never be satisfied is a wiring bug, not an access denial.
+	if err := validateRequirement(req); err != nil {
+		log.Fatalf("Invalid route requirement %+v: %v", req, err)
+	}
+	return func(c *fiber.Ctx) error {
+		claims, user, status, err := resolveCaller(c)
+		if err != nil {
+			if status == fiber.StatusForbidden {
+				logDenial(c, claims, req, err.Error())
 			}
-			// Grant access if the path and country are permitted by the rule.
-			if matchPath(perm.Path, req.Path) && isCountryPermitted(req.Country, perm) {
-				return true
+			return denyWithCode(c, status, userErrorCode(err), err.Error())
+		}
+		check := evaluateRequirement(c, claims, user, req)
+		if !check.satisfied() {
+			if check.reason != "" {
+				logDenial(c, claims, check.target, check.reason)
 			}
+			return denyWithCode(c, check.status, check.code, check.message)
 		}
+		// Store the resolved user object and the decision in the context for handlers to use.
+		c.Locals("user", user)
+		c.Locals("decision", check.decision)
+		return c.Next()
 	}
-	return false
 }
 
-// ------------------------------------
-// JWT to User + Role Mapping
-// ------------------------------------
-
 /*
-extractUser parses JWT claims, retrieves the associated roles from MongoDB,
-and builds a User object with all permissions and a computed list of allowed countries.
+resolveCaller returns the caller's claims and User: the token's user, or the anonymous
+user for a tokenless request when anonymous access is enabled. On failure it returns the
+status to deny with, 401 for a missing or invalid token and 403 when the user's roles
+cannot be resolved.
 */
-func extractUser(claims jwt.MapClaims) (*User, error) {
-	username, ok := claims["preferred_username"].(string)
-	if !ok {
-		return nil, fmt.Errorf("preferred_username missing or not a string in token")
-	}
-	rolesIface, ok := claims["roles"].([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("roles claim missing or in wrong format")
+func resolveCaller(c *fiber.Ctx) (jwt.MapClaims, *User, int, error) {
+	var user *User
+	claims, err := requestClaims(c)
+	switch {
+	case err == nil:
+		user, err = extractUser(claims)
+	case isAnonymousRequest(c):
+		claims = anonymousClaims()
+		user, err = anonymousUser()
+	default:
+		return nil, nil, fiber.StatusUnauthorized, err
 	}
-
-	var roleIDs []string
-	for _, r := range rolesIface {
-		if s, ok := r.(string); ok {
-			roleIDs = append(roleIDs, s)
-		}
+	if err != nil {
+		return claims, nil, fiber.StatusForbidden, err
 	}
+	return claims, user, 0, nil
+}
 
-	rolesCollection := mongoDB.Collection("roles")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// requirementCheck is the outcome of evaluating one Requirement for a resolved caller. A
+// failed check carries the denial status, optional code, and client message, plus the
+// reason to log (empty for malformed requests, which are not logged as denials).
+type requirementCheck struct {
+	target   Requirement
+	decision Decision
+	status   int
+	code     string
+	message  string
+	reason   string
+}
 
-	var roles []Role
-	countrySet := make(map[string]struct{})
+/*
+satisfied reports whether the check passed.
+*/
+func (rc requirementCheck) satisfied() bool {
+	return rc.status == 0
+}
 
-	for _, roleID := range roleIDs {
-		var role Role
-		err := rolesCollection.FindOne(ctx, bson.M{"role_id": roleID}).Decode(&role)
+/*
+evaluateRequirement resolves the Requirement's target country, owner, and attributes from
+the request and checks it for the caller: the permission decision, the decision hook, then
+step-up, token freshness, and the feature flag. It writes no response, so composites can evaluate several
+Requirements before deciding.
+*/
+func evaluateRequirement(c *fiber.Ctx, claims jwt.MapClaims, user *User, req Requirement) requirementCheck {
+	target := req
+	if req.Country == CountryFromToken {
+		country, err := tokenCountry(claims)
 		if err != nil {
-			// Log the actual error for debugging but return a generic message to the client.
-			log.Printf("Failed to find role '%s' in database: %v", roleID, err)
-			return nil, fmt.Errorf("permission check failed: could not resolve user roles")
+			return requirementCheck{target: req, status: fiber.StatusForbidden, message: err.Error(), reason: err.Error()}
 		}
-
-		// Calculate the set of all countries this user is allowed to access.
-		for _, perm := range role.Permissions {
-			for _, r := range perm.Regions {
-				if r == "GLOBAL" || r == "*" {
-					countrySet["*"] = struct{}{}
-				} else if countries, ok := regionMap()[r]; ok {
-					for _, c := range countries {
-						countrySet[c] = struct{}{}
-					}
-				}
-			}
-			for _, c := range perm.Countries {
-				countrySet[c] = struct{}{}
-			}
+		target.Country = country
+	}
+	if param, ok := strings.CutPrefix(req.Country, CountryParamPrefix); ok {
+		country := normalizeCountry(c.Params(param))
+		if country == "" {
+			return requirementCheck{target: req, status: fiber.StatusBadRequest,
+				message: fmt.Sprintf("missing country in route parameter %q", param)}
 		}
-		roles = append(roles, role)
+		if !isKnownCountry(country) {
+			return requirementCheck{target: req, status: fiber.StatusBadRequest,
+				message: fmt.Sprintf("%q is not a valid ISO-2 country code", country)}
+		}
+		target.Country = country
 	}
-
-	var countries []string
-	for c := range countrySet {
-		countries = append(countries, c)
+	if req.OwnerParam != "" {
+		target.Owner = c.Params(req.OwnerParam)
 	}
-
-	return &User{
-		ID:               username,
-		AllowedCountries: countries,
-		Roles:            roles,
-	}, nil
+	if req.AttributesFrom != nil {
+		target.Attributes = req.AttributesFrom(c)
+	}
+	decision := cachedDecide(user, target)
+	recordDecision(decision)
+	allowed := decision.Allowed
+	// Candidate roles are compared against the built-in decision, before the hook.
+	if shadowCollection != "" && user.ID != anonymousUserID {
+		enqueueShadow(claims, user, target, allowed)
+	}
+	check := requirementCheck{target: target, decision: decision}
+	var reason string
+	if err := decision.Err(); err != nil {
+		reason = err.Error()
+	}
+	// The hook can only narrow the decision, never widen a denial.
+	if decisionHook != nil && !decisionHook(c, user, target, allowed) && allowed {
+		allowed, reason = false, "permission denied: decision hook"
+	}
+	missingRoles := missingRequiredRoles(claims, req.RequiredRoles)
+	switch {
+	case decision.Reason == decisionLegallyRestricted:
+		check.status, check.code, check.reason = fiber.StatusUnavailableForLegalReasons, legallyRestrictedCode, reason
+		check.message = "This resource is unavailable in " + target.Country + " for legal reasons."
+	case !allowed:
+		check.status, check.code, check.reason = fiber.StatusForbidden, "PERMISSION_DENIED", reason
+		check.message = "Access denied. You do not have permission for this resource."
+		if req.DenyMessage != "" {
+			check.message = req.DenyMessage
+		}
+	case len(missingRoles) > 0:
+		check.status, check.code = fiber.StatusForbidden, roleRequiredCode
+		check.reason = "missing required roles: " + strings.Join(missingRoles, ",")
+		check.message = "This resource requires the " + strings.Join(missingRoles, ", ") + " role."
+	case !meetsAuthLevel(claims, req):
+		check.status, check.code, check.reason = fiber.StatusForbidden, "STEP_UP_REQUIRED", "step-up required"
+		check.message = "Stronger authentication is required for this resource."
+	default:
+		if err := checkTokenFreshness(claims, req.MaxTokenAge); err != nil {
+			check.status, check.code, check.reason = fiber.StatusForbidden, "REAUTH_REQUIRED", err.Error()
+			check.message = "Recent authentication is required for this resource. Please sign in again."
+			break
+		}
+		// The flag is only consulted once the caller is authorized, so a disabled feature
+		// never reveals more than a denial would.
+		if req.Feature != "" && !featureEnabled(req.Feature, user) {
+			check.status, check.reason = featureOffStatus, "feature "+req.Feature+" is disabled"
+			check.message = "Not Found"
+			if featureOffStatus == fiber.StatusForbidden {
+				check.code, check.message = featureDisabledCode, "This feature is not enabled for you."
+			}
+		}
+	}
+	return check
 }
 
-// ------------------------------------
-// Middleware
-// ------------------------------------
-
 /*
-requirePermission returns a Fiber middleware. It parses the JWT, builds the user's
-permission profile from MongoDB, and denies access if the required permissions are not met.
+requireAuthenticated returns a Fiber middleware that parses the JWT and builds the user's
+permission profile without enforcing any specific Requirement. It is used by introspection
+endpoints that describe what the caller can do.
 */
-func requirePermission(req Requirement) fiber.Handler {
+func requireAuthenticated() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		claims, err := parseToken(c)
+		claims, err := requestClaims(c)
 		if err != nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+			return deny(c, fiber.StatusUnauthorized, err.Error())
 		}
 		user, err := extractUser(claims)
 		if err != nil {
-			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
-		}
-		if !IsAllowed(user, req) {
-			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-				"error": "Access denied. You do not have permission for this resource.",
-			})
+			return denyWithCode(c, fiber.StatusForbidden, userErrorCode(err), err.Error())
 		}
-		// Store the resolved user object in the context for handlers to use.
 		c.Locals("user", user)
 		return c.Next()
 	}
 }
 
+/*
+AuthorizeResource performs object-level authorization from inside a handler, after the
+resource has been fetched and its country is known. It re-uses the User already loaded by
+requirePermission and returns a 403 *fiber.Error when access to that country is denied.
+*/
+func AuthorizeResource(c *fiber.Ctx, path, country string) error {
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	if !IsAllowed(user, Requirement{Path: path, Country: country}) {
+		return fiber.NewError(fiber.StatusForbidden, "Access denied. You do not have permission for this resource.")
+	}
+	return nil
+}
+
+/*
+profileHandler serves GET /user/profile: the caller's roles and countries, and the role
+whose permission allowed the request.
+*/
+func profileHandler(c *fiber.Ctx) error {
+	// Retrieve the user object already processed by the middleware.
+	user, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	// Construct the response with detailed user info, including the role that granted access.
+	decision, _ := c.Locals("decision").(Decision)
+	return sendResponse(c, ProfileResponse{
+		User:             user.ID,
+		Roles:            user.Roles, // This will be the full role object from Mongo.
+		AllowedCountries: user.AllowedCountries,
+		GrantedByRole:    decision.RoleID,
+	})
+}
+
 // ------------------------------------
 // Mongo Setup
 // ------------------------------------
 
 /*
 initMongo initializes the connection to the MongoDB database using an
-environment variable for the URI and a default fallback.
+environment variable for the URI and a default fallback. Each attempt is bounded by
+MONGO_CONNECT_TIMEOUT (10s); a failed attempt is retried up to MONGO_CONNECT_RETRIES (5)
+times, waiting MONGO_CONNECT_BACKOFF (1s) first and doubling the wait up to 30s, so the
+service survives MongoDB starting a few seconds after it.
 */
 func initMongo() {
 	mongoURI := os.Getenv("MONGO_URI")
 	if mongoURI == "" {
 		mongoURI = "mongodb://localhost:27017"
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	clientOptions := options.Client().ApplyURI(mongoURI)
-	client, err := mongo.Connect(ctx, clientOptions)
-	if err != nil {
-		log.Fatal("Mongo Connect error:", err)
+	timeout := envDuration("MONGO_CONNECT_TIMEOUT", 10*time.Second)
+	backoff := envDuration("MONGO_CONNECT_BACKOFF", time.Second)
+	retries := 5
+	if v := os.Getenv("MONGO_CONNECT_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid MONGO_CONNECT_RETRIES %q: must be a non-negative integer", v)
+		}
+		retries = n
 	}
-	if err = client.Ping(ctx, nil); err != nil {
-		log.Fatal("Mongo Ping error:", err)
+
+	var client *mongo.Client
+	var err error
+	for attempt := 1; ; attempt++ {
+		client, err = connectMongo(mongoURI, timeout)
+		if err == nil {
+			break
+		}
+		if attempt > retries {
+			log.Fatalf("Mongo connection failed after %d attempts: %v", attempt, err)
+		}
+		logWarn("mongo connection failed, retrying", "attempt", attempt, "max_attempts", retries+1, "retry_in", backoff, "error", err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
 	}
 	mongoClient = client
 	dbName := os.Getenv("MONGO_DB")
@@ -360,7 +1625,42 @@ func initMongo() {
 		dbName = "demo_db"
 	}
 	mongoDB = client.Database(dbName)
-	log.Println("Connected to MongoDB:", mongoURI)
+	logInfo("connected to MongoDB", "uri", mongoURI)
+	initReadOnlyMongo(dbName)
+}
+
+/*
+connectMongo makes one connection attempt, connecting and pinging within timeout. A client
+whose ping fails is disconnected so retries do not leak connection pools.
+*/
+func connectMongo(uri string, timeout time.Duration) (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, fmt.Errorf("ping: %w", err)
+	}
+	return client, nil
+}
+
+/*
+envDuration reads a positive duration from the named environment variable, returning
+fallback when it is unset and stopping the service when it is invalid.
+*/
+func envDuration(name string, fallback time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("Invalid %s %q: must be a positive duration such as %s", name, v, fallback)
+	}
+	return d
 }
 
 // ------------------------------------
@@ -372,60 +1672,165 @@ main is the entry point of the application. It initializes the database connecti
 sets up the Fiber HTTP routes and middleware, and starts the server.
 */
 func main() {
+	initLogLevel()
+	logStartupBanner()
+	validateConfig()
 	initMongo()
+	initSeed()
+	initRoleCache()
+	initRegions()
+	initJTIDenylist()
+	initClaimsConfig()
+	initTokenVerification()
+	initExpansionLimits()
+	initRoleResolutionMode()
+	initDenyResponseFormat()
+	initPathMatching()
+	initExclusionPrecedence()
+	initDenialLogging()
+	initAnonymousAccess()
+	initGRPC()
+	initRoutePolicies()
+	initDenialWebhook()
+	initShadowMode()
+	initRoleValidation()
+	initAudienceScoping()
+	initInternalToken()
+	initDecisionMetrics()
+	initUserOverrides()
+	initUsernamePolicy()
+	initBulkCheck()
+	initAccessDiff()
+	initDecisionCache()
+	initCacheBackend()
+	initGlobalExclusions()
+	initGroupMappings()
+	initEmptyCountriesPolicy()
+	initTLS()
+	initResponseCasing()
+	initFeatureFlags()
+	initSanctionedCountries()
+
+	app := fiber.New(fiber.Config{BodyLimit: bodyLimit(), ErrorHandler: errorHandler})
+
+	// Request ids and panic recovery wrap everything else, so even a failing middleware
+	// is logged with its request id and answered with a clean 500.
+	app.Use(requestIDMiddleware())
+	app.Use(recoverMiddleware())
+
+	// Resolve the real client IP first so every later middleware can use it.
+	initTrustedProxies()
+	app.Use(clientIPMiddleware())
+
+	// Global per-route rate limits run before authentication so spikes never reach MongoDB.
+	if mw := routeRateLimiter(); mw != nil {
+		app.Use(mw)
+	}
+
+	// Optional response compression, skipped for health and metrics.
+	if mw := compressionMiddleware(); mw != nil {
+		app.Use(mw)
+	}
+
+	// Authentication gate: everything outside PUBLIC_PATHS needs a Bearer token.
+	initPublicPaths()
+	app.Use(authGate())
 
-	app := fiber.New()
+	// Per-client rate limit on admin endpoints only; RBAC checks elsewhere are unaffected.
+	if mw := adminRateLimiter(); mw != nil {
+		app.Use("/admin", mw)
+	}
+
+	// All routes go through the router so the public/protected split is explicit
+	// and the resulting route table can be logged at startup.
+	router := newRouter(app)
 
 	// Public endpoint, does not require authentication or permissions.
-	app.Get("/public", func(c *fiber.Ctx) error {
+	router.Public(fiber.MethodGet, "/public", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{"message": "This is a public endpoint."})
 	})
 
+	// Metrics endpoint in Prometheus text format, public for scrapers.
+	router.Public(fiber.MethodGet, "/metrics", metricsHandler)
+
+	// Health endpoint, public for probes; reports MongoDB and RBAC data freshness.
+	router.Public(fiber.MethodGet, "/healthz", healthHandler)
+
+	// Envoy ext_authz endpoint, evaluates forwarded requests via the route-to-permission table.
+	for _, method := range extAuthzMethods {
+		router.Mapped(method, extAuthzPrefix+"/*", extAuthzCheck, extAuthzAllow)
+	}
+
+	// Policy decision endpoint, reports decisions for external enforcers without enforcing.
+	router.Public(fiber.MethodPost, "/authorize", authorizeHandler)
+
+	// Version endpoint, public build metadata for identifying deployments.
+	router.Public(fiber.MethodGet, "/version", versionHandler)
+
+	// OpenAPI contract of every registered route; it needs a token when PUBLIC_PATHS omits it.
+	if isPublicPath("/openapi.json") {
+		router.Public(fiber.MethodGet, "/openapi.json", openAPIHandler(router))
+	} else {
+		router.Authenticated(fiber.MethodGet, "/openapi.json", openAPIHandler(router))
+	}
+
 	// Profile endpoint, protected by RBAC middleware.
-	app.Get("/user/profile", requirePermission(Requirement{
+	router.Protected(fiber.MethodGet, "/user/profile", Requirement{
 		Path:    "hr:profile:view",
 		Country: "GLOBAL",
-	}), func(c *fiber.Ctx) error {
-		// Retrieve the user object already processed by the middleware.
-		user := c.Locals("user").(*User)
+	}, profileHandler)
 
-		// Construct the response with detailed user info.
-		return c.JSON(fiber.Map{
-			"user":              user.ID,
-			"roles":             user.Roles, // This will be the full role object from Mongo.
-			"allowed_countries": user.AllowedCountries,
-		})
-	})
+	// Capabilities endpoint, describes the caller's effective permissions (ETag-aware).
+	router.Authenticated(fiber.MethodGet, "/rbac/capabilities", capabilitiesHandler)
+
+	// Bulk permission check for menu rendering, one decision per candidate path.
+	router.Authenticated(fiber.MethodPost, "/rbac/bulk-check", bulkCheckHandler)
+
+	// Reverse region lookup, lists the regions (including composites) containing a country.
+	router.Authenticated(fiber.MethodGet, "/rbac/country/:code/regions", countryRegionsHandler)
 
 	// User data endpoint, protected by RBAC middleware.
-	app.Get("/user", requirePermission(Requirement{
+	router.Protected(fiber.MethodGet, "/user", Requirement{
 		Path:    "hr:user:view",
 		Country: "GLOBAL",
-	}), func(c *fiber.Ctx) error {
+	}, func(c *fiber.Ctx) error {
 		// The 'requirePermission' middleware already parsed the user and stored it.
 		// We can retrieve it from the context.
-		user := c.Locals("user").(*User)
+		user, err := currentUser(c)
+		if err != nil {
+			return err
+		}
 
 		// Return general, non-sensitive user data.
-		return c.JSON(fiber.Map{
-			"username":          user.ID,
-			"allowed_countries": user.AllowedCountries,
+		return sendResponse(c, UserSummaryResponse{
+			Username:         user.ID,
+			AllowedCountries: user.AllowedCountries,
 		})
 	})
 
 	// Payroll endpoint with country-specific permission requirement.
-	app.Get("/user/payroll", requirePermission(Requirement{
-		Path:    "hr:payroll:view",
-		Country: "TH",
-	}), func(c *fiber.Ctx) error {
+	router.Protected(fiber.MethodGet, "/user/payroll", Requirement{
+		Path:        "hr:payroll:view",
+		Country:     "TH",
+		DenyMessage: "Payroll access requires country-level HR permission.",
+	}, func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{"message": "Authorized to view payroll in Thailand"})
 	})
 
+	// Payroll audit needs both the payroll and the finance audit permission; a denial lists
+	// which of the two the caller is missing.
+	router.ProtectedAll(fiber.MethodGet, "/hr/payroll/audit", []Requirement{
+		{Path: "hr:payroll:view", Country: "TH"},
+		{Path: "finance:audit:read", Country: "GLOBAL"},
+	}, func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"message": "Authorized to audit payroll in Thailand"})
+	})
+
 	// Admin-only endpoint for viewing item data.
-	app.Get("/admin/items", requirePermission(Requirement{
+	router.Protected(fiber.MethodGet, "/admin/items", Requirement{
 		Path:    "admin:items:view",
 		Country: "GLOBAL",
-	}), func(c *fiber.Ctx) error {
+	}, func(c *fiber.Ctx) error {
 		if mongoDB == nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "MongoDB not initialized",
@@ -450,6 +1855,82 @@ func main() {
 		})
 	})
 
-	log.Println("Server started on port 3000")
-	log.Fatal(app.Listen(":3000"))
+	// Websocket endpoint, the RBAC gate runs during the HTTP upgrade handshake.
+	router.Protected(fiber.MethodGet, "/ws/notifications", Requirement{
+		Path:    "hr:notifications:view",
+		Country: "GLOBAL",
+	}, requireWebSocketUpgrade, websocket.New(notificationsSocket))
+
+	// Effective region mapping (built-in base merged with overrides), for debugging.
+	router.Protected(fiber.MethodGet, "/admin/regions", Requirement{
+		Path:    "admin:regions:view",
+		Country: "GLOBAL",
+	}, regionsHandler)
+
+	// Effective runtime RBAC configuration, for debugging.
+	router.Protected(fiber.MethodGet, "/admin/debug/config", Requirement{
+		Path:    "admin:debug:view",
+		Country: "GLOBAL",
+	}, debugConfigHandler)
+
+	// Keycloak connectivity check: discovery document and JWKS reachability, no key material.
+	router.Protected(fiber.MethodGet, "/admin/debug/keycloak", Requirement{
+		Path:    "admin:debug:view",
+		Country: "GLOBAL",
+	}, keycloakDebugHandler)
+
+	// Streaming export of effective permissions for security audits (JSON or CSV).
+	router.Protected(fiber.MethodGet, "/admin/audit/export", Requirement{
+		Path:    "admin:audit:export",
+		Country: "GLOBAL",
+	}, auditExportHandler)
+
+	// Incident response: revoke a specific token by its jti.
+	router.Protected(fiber.MethodPost, "/admin/tokens/revoke", Requirement{
+		Path:    "admin:tokens:revoke",
+		Country: "GLOBAL",
+	}, revokeTokenHandler)
+
+	// Kill-switch: global path exclusions that deny regardless of any grant.
+	router.Protected(fiber.MethodGet, "/admin/exclusions", Requirement{
+		Path:    "admin:exclusions:view",
+		Country: "GLOBAL",
+	}, listExclusionsHandler)
+	router.Protected(fiber.MethodPost, "/admin/exclusions", Requirement{
+		Path:    "admin:exclusions:edit",
+		Country: "GLOBAL",
+	}, addExclusionHandler)
+	router.Protected(fiber.MethodDelete, "/admin/exclusions", Requirement{
+		Path:    "admin:exclusions:edit",
+		Country: "GLOBAL",
+	}, removeExclusionHandler)
+	router.Protected(fiber.MethodPost, "/admin/exclusions/reload", Requirement{
+		Path:    "admin:exclusions:edit",
+		Country: "GLOBAL",
+	}, reloadExclusionsHandler)
+
+	// Admin role management, strict JSON bodies.
+	router.Protected(fiber.MethodPut, "/admin/roles/:id", Requirement{
+		Path:    "admin:roles:edit",
+		Country: "GLOBAL",
+	}, upsertRoleHandler)
+
+	// Read-only what-if analysis of a proposed role change.
+	router.Protected(fiber.MethodPost, "/admin/roles/:id/simulate", Requirement{
+		Path:    "admin:roles:view",
+		Country: "GLOBAL",
+	}, simulateRoleHandler)
+
+	// Access diff endpoint, compares two users' decisions over a path x country matrix.
+	router.Protected(fiber.MethodPost, "/admin/access/diff", Requirement{
+		Path:    "admin:roles:view",
+		Country: "GLOBAL",
+	}, diffAccessHandler)
+
+	// Routes declared in ROUTE_MANIFEST_FILE, wired to the named handlers.
+	loadRouteManifest(router)
+
+	router.LogRoutes()
+	logInfo("server started", "port", 3000)
+	log.Fatal(listen(app, ":3000"))
 }
@@ -0,0 +1,672 @@
+// main_test.go
+//
+// Tests for token parsing, user construction, and the RBAC decision core.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/fiber-demo/testutil"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+/*
+useCachedRoles serves roles from an in-memory role cache for the duration of the test, so
+extractUser resolves them without MongoDB.
+*/
+func useCachedRoles(t *testing.T, roles ...Role) {
+	t.Helper()
+	cache := newRoleCache(time.Minute, 0)
+	for _, role := range roles {
+		cache.set(role.RoleID, role)
+	}
+	previous := roleCaches
+	roleCaches = memoryBackend{roles: cache}
+	t.Cleanup(func() { roleCaches = previous })
+}
+
+func TestExtractRoleIDsDeduplicates(t *testing.T) {
+	claims := jwt.MapClaims{"roles": []interface{}{"viewer", "viewer"}}
+	ids, err := extractRoleIDs(claims)
+	if err != nil {
+		t.Fatalf("extractRoleIDs: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != "viewer" {
+		t.Fatalf("extractRoleIDs = %v, want [viewer]", ids)
+	}
+}
+
+func TestExtractUserLoadsRepeatedRoleOnce(t *testing.T) {
+	useCachedRoles(t, Role{RoleID: "viewer", Permissions: []Permission{
+		{Path: "hr:profile:view", Countries: []string{"TH"}},
+	}})
+	claims := jwt.MapClaims{
+		"preferred_username": "alice",
+		"sub":                "alice-id",
+		"roles":              []interface{}{"viewer", "viewer"},
+	}
+	user, err := extractUser(claims)
+	if err != nil {
+		t.Fatalf("extractUser: %v", err)
+	}
+	if len(user.Roles) != 1 || user.Roles[0].RoleID != "viewer" {
+		t.Fatalf("roles = %v, want only viewer", user.Roles)
+	}
+	if len(user.AllowedCountries) != 1 || user.AllowedCountries[0] != "TH" {
+		t.Fatalf("allowed countries = %v, want [TH]", user.AllowedCountries)
+	}
+}
+
+func TestExtractUserSkipsDisabledRoles(t *testing.T) {
+	useCachedRoles(t,
+		Role{RoleID: "viewer", Permissions: []Permission{{Path: "hr:profile:view", Countries: []string{"TH"}}}},
+		Role{RoleID: "retired", Disabled: true, Permissions: []Permission{{Path: "hr:**", Regions: []string{"GLOBAL"}}}},
+	)
+	claims := jwt.MapClaims{"preferred_username": "alice", "roles": []interface{}{"viewer", "retired"}}
+	user, err := extractUser(claims)
+	if err != nil {
+		t.Fatalf("extractUser: %v", err)
+	}
+	if len(user.Roles) != 1 || user.Roles[0].RoleID != "viewer" {
+		t.Fatalf("roles = %v, want only viewer", user.Roles)
+	}
+	if IsAllowed(user, Requirement{Path: "hr:payroll:view", Country: "TH"}) {
+		t.Error("disabled role granted hr:payroll:view")
+	}
+	if IsAllowed(user, Requirement{Path: "hr:profile:view", Country: "US"}) {
+		t.Error("disabled role widened the country scope to US")
+	}
+	if !IsAllowed(user, Requirement{Path: "hr:profile:view", Country: "TH"}) {
+		t.Error("enabled role no longer grants hr:profile:view in TH")
+	}
+}
+
+func TestUserFromRolesSkipsDisabledRoles(t *testing.T) {
+	user := userFromRoles("sim", []Role{{RoleID: "retired", Disabled: true, Permissions: []Permission{
+		{Path: "hr:**", Regions: []string{"GLOBAL"}},
+	}}})
+	if len(user.Roles) != 0 || len(user.AllowedCountries) != 0 {
+		t.Fatalf("user = %+v, want no roles and no countries", user)
+	}
+	if IsAllowed(user, Requirement{Path: "hr:profile:view", Country: "GLOBAL"}) {
+		t.Error("disabled role granted a GLOBAL requirement")
+	}
+}
+
+func TestIsCountryPermittedPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		perm     Permission
+		country  string
+		specific bool
+		want     bool
+	}{
+		{"excluded country beats listed country", Permission{Regions: []string{"ASIA"}, Countries: []string{"TH"}, ExceptCountries: []string{"TH"}}, "TH", false, false},
+		{"excluded country beats listed country when specific", Permission{Countries: []string{"TH"}, ExceptCountries: []string{"TH"}}, "TH", true, false},
+		{"excluded country beats wildcard", Permission{Countries: []string{"*"}, ExceptCountries: []string{"TH"}}, "TH", false, false},
+		{"excluded region beats listed country when strict", Permission{Countries: []string{"TH"}, ExceptRegions: []string{"ASIA"}}, "TH", false, false},
+		{"listed country beats excluded region when specific", Permission{Countries: []string{"TH"}, ExceptRegions: []string{"ASIA"}}, "TH", true, true},
+		{"excluded region beats granted region", Permission{Regions: []string{"GLOBAL"}, ExceptRegions: []string{"ASIA"}}, "JP", true, false},
+		{"other countries of the region stay granted", Permission{Regions: []string{"ASIA"}, ExceptCountries: []string{"TH"}}, "JP", false, true},
+	}
+	t.Cleanup(func() { explicitCountryBeatsRegionExclusion = false })
+	for _, tt := range tests {
+		explicitCountryBeatsRegionExclusion = tt.specific
+		if got := isCountryPermitted(tt.country, tt.perm); got != tt.want {
+			t.Errorf("%s: isCountryPermitted(%s) = %v, want %v", tt.name, tt.country, got, tt.want)
+		}
+	}
+}
+
+func TestAddedCountriesRespectPermissionExclusions(t *testing.T) {
+	user := userFromRoles("alice", []Role{{RoleID: "staff", Permissions: []Permission{
+		{Path: "hr:**", Countries: []string{"TH"}, ExceptCountries: []string{"VN"}},
+		{Path: "finance:**", Countries: []string{"US"}, ExceptRegions: []string{"ASIA"}},
+	}}})
+	user.addedCountries = []string{"JP", "VN"}
+	user.AllowedCountries = append(user.AllowedCountries, user.addedCountries...)
+
+	tests := []struct {
+		path    string
+		country string
+		allowed bool
+	}{
+		{"hr:profile:view", "JP", true},
+		{"hr:profile:view", "VN", false},
+		{"finance:ledger:view", "US", true},
+		{"finance:ledger:view", "JP", false},
+		{"finance:ledger:view", "VN", false},
+	}
+	for _, tt := range tests {
+		if got := IsAllowed(user, Requirement{Path: tt.path, Country: tt.country}); got != tt.allowed {
+			t.Errorf("IsAllowed(%s, %s) = %v, want %v", tt.path, tt.country, got, tt.allowed)
+		}
+	}
+	if got := strings.Join(AllowedCountriesForPath(user, "hr:profile:view"), ","); got != "JP,TH" {
+		t.Errorf("AllowedCountriesForPath(hr:profile:view) = %s, want JP,TH", got)
+	}
+	if got := strings.Join(AllowedCountriesForPath(user, "finance:ledger:view"), ","); got != "US" {
+		t.Errorf("AllowedCountriesForPath(finance:ledger:view) = %s, want US", got)
+	}
+}
+
+func TestUnionCountriesClaimRespectsPermissionExclusions(t *testing.T) {
+	allowedCountriesClaim, allowedCountriesUnion = "allowed_countries", true
+	t.Cleanup(func() { allowedCountriesClaim, allowedCountriesUnion = "", false })
+	user := userFromRoles("alice", []Role{{RoleID: "staff", Permissions: []Permission{
+		{Path: "hr:**", Countries: []string{"TH"}, ExceptCountries: []string{"VN"}},
+	}}})
+	claims := jwt.MapClaims{"allowed_countries": []interface{}{"JP", "VN"}}
+	if err := applyCountriesClaim(user, claims); err != nil {
+		t.Fatalf("applyCountriesClaim = %v", err)
+	}
+	for country, want := range map[string]bool{"TH": true, "JP": true, "VN": false} {
+		if got := IsAllowed(user, Requirement{Path: "hr:profile:view", Country: country}); got != want {
+			t.Errorf("IsAllowed(hr:profile:view, %s) = %v, want %v", country, got, want)
+		}
+	}
+}
+
+func TestMissingRequiredRolesSkipsExpiredRoles(t *testing.T) {
+	claims := jwt.MapClaims{
+		"roles": []interface{}{"auditor", "manager"},
+		"role_expiry": map[string]interface{}{
+			"auditor": float64(time.Now().Add(-time.Hour).Unix()),
+			"manager": float64(time.Now().Add(time.Hour).Unix()),
+		},
+	}
+	missing := missingRequiredRoles(claims, []string{"auditor", "manager"})
+	if len(missing) != 1 || missing[0] != "auditor" {
+		t.Fatalf("missingRequiredRoles = %v, want [auditor]", missing)
+	}
+}
+
+func TestValidatePermissionReportsIncludedAndExcludedCountry(t *testing.T) {
+	errs := ValidatePermission(Permission{Path: "hr:profile:view", Regions: []string{"ASIA"},
+		Countries: []string{"TH"}, ExceptCountries: []string{"TH"}})
+	if len(errs) != 1 {
+		t.Fatalf("ValidatePermission = %v, want one conflict", errs)
+	}
+}
+
+func TestExtractUserCapsAllowedCountries(t *testing.T) {
+	previous := maxAllowedCountries
+	maxAllowedCountries = 3
+	t.Cleanup(func() { maxAllowedCountries = previous })
+	useCachedRoles(t, Role{RoleID: "asia", Permissions: []Permission{{Path: "hr:**", Regions: []string{"ASIA"}}}})
+	claims := jwt.MapClaims{"preferred_username": "alice", "roles": []interface{}{"asia"}}
+	if _, err := extractUser(claims); err == nil {
+		t.Fatal("extractUser accepted a role expanding past MAX_ALLOWED_COUNTRIES")
+	}
+}
+
+func TestWildcardCountry(t *testing.T) {
+	wildcard := Permission{Path: "hr:profile:view", Countries: []string{"*"}}
+	tests := []struct {
+		name    string
+		perm    Permission
+		country string
+		want    bool
+	}{
+		{"any single country", wildcard, "TH", true},
+		{"another country", wildcard, "US", true},
+		{"alias of a country", wildcard, "UK", true},
+		{"ExceptCountries overrides *", Permission{Countries: []string{"*"}, ExceptCountries: []string{"TH"}}, "TH", false},
+		{"ExceptRegions overrides *", Permission{Countries: []string{"*"}, ExceptRegions: []string{"ASIA"}}, "JP", false},
+		{"* outside the excluded region", Permission{Countries: []string{"*"}, ExceptRegions: []string{"ASIA"}}, "FR", true},
+	}
+	for _, tt := range tests {
+		if got := isCountryPermitted(normalizeCountry(tt.country), tt.perm); got != tt.want {
+			t.Errorf("%s: isCountryPermitted(%s) = %v, want %v", tt.name, tt.country, got, tt.want)
+		}
+	}
+}
+
+func TestWildcardCountryUser(t *testing.T) {
+	user := userFromRoles("alice", []Role{{RoleID: "any", Permissions: []Permission{
+		{Path: "hr:profile:view", Countries: []string{"*"}},
+	}}})
+	if len(user.AllowedCountries) != 1 || user.AllowedCountries[0] != "*" {
+		t.Fatalf("allowed countries = %v, want [*]", user.AllowedCountries)
+	}
+	for _, country := range []string{"TH", "US"} {
+		if !IsAllowed(user, Requirement{Path: "hr:profile:view", Country: country}) {
+			t.Errorf("Countries [*] denied %s", country)
+		}
+	}
+	// "*" stands for any single country, not for GLOBAL, which only the GLOBAL region grants.
+	if IsAllowed(user, Requirement{Path: "hr:profile:view", Country: "GLOBAL"}) {
+		t.Error("Countries [*] satisfied a GLOBAL requirement")
+	}
+	global := userFromRoles("bob", []Role{{RoleID: "global", Permissions: []Permission{
+		{Path: "hr:profile:view", Regions: []string{"GLOBAL"}},
+	}}})
+	if !IsAllowed(global, Requirement{Path: "hr:profile:view", Country: "GLOBAL"}) {
+		t.Error("Regions [GLOBAL] denied a GLOBAL requirement")
+	}
+}
+
+func TestMergeKeepsWildcardCountryApartFromGlobal(t *testing.T) {
+	user := userFromRoles("alice", []Role{{RoleID: "any", Permissions: []Permission{
+		{Path: "hr:profile:view", Countries: []string{"*"}},
+	}}})
+	merged := MergeEffectivePermissions(user)
+	if len(merged) != 1 || len(merged[0].Regions) != 0 || len(merged[0].Countries) != 1 || merged[0].Countries[0] != "*" {
+		t.Fatalf("merged = %+v, want countries [*] without the GLOBAL region", merged)
+	}
+}
+
+func TestMatchPathCaseSensitivity(t *testing.T) {
+	t.Cleanup(func() { pathCaseSensitive = false })
+	tests := []struct {
+		pattern, target string
+		insensitive     bool
+		sensitive       bool
+	}{
+		{"hr:profile:view", "hr:profile:view", true, true},
+		{"HR:Profile:View", "hr:profile:view", true, false},
+		{"hr:*:view", "hr:Profile:view", true, true},
+		{"hr:report_*", "hr:REPORT_2024", true, false},
+		{"hr:**", "HR:payroll:view", true, false},
+	}
+	for _, tt := range tests {
+		pathCaseSensitive = false
+		if got := matchPath(tt.pattern, tt.target); got != tt.insensitive {
+			t.Errorf("insensitive matchPath(%q, %q) = %v, want %v", tt.pattern, tt.target, got, tt.insensitive)
+		}
+		pathCaseSensitive = true
+		if got := matchPath(tt.pattern, tt.target); got != tt.sensitive {
+			t.Errorf("sensitive matchPath(%q, %q) = %v, want %v", tt.pattern, tt.target, got, tt.sensitive)
+		}
+	}
+}
+
+func TestCaseSensitiveExceptPaths(t *testing.T) {
+	pathCaseSensitive = true
+	t.Cleanup(func() { pathCaseSensitive = false })
+	user := userFromRoles("alice", []Role{{RoleID: "hr", Permissions: []Permission{
+		{Path: "hr:**", Countries: []string{"TH"}, ExceptPaths: []string{"hr:Payroll:**"}},
+	}}})
+	if !IsAllowed(user, Requirement{Path: "hr:payroll:view", Country: "TH"}) {
+		t.Error("differently cased path was excluded in case-sensitive mode")
+	}
+	if IsAllowed(user, Requirement{Path: "hr:Payroll:view", Country: "TH"}) {
+		t.Error("exactly cased path was not excluded in case-sensitive mode")
+	}
+}
+
+func TestPathInheritance(t *testing.T) {
+	user := userFromRoles("alice", []Role{{RoleID: "hr", Permissions: []Permission{
+		{Path: "hr:**", Countries: []string{"TH"}, ExceptPaths: []string{"hr:payroll:**"}},
+	}}})
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"hr", true},
+		{"hr:profile", true},
+		{"hr:profile:view", true},
+		{"hr:profile:photo:edit", true},
+		{"hr:payroll", false},
+		{"hr:payroll:view", false},
+		{"hr:payroll:bonus:approve", false},
+		{"hr:payrollx:view", true},
+		{"finance:payroll:view", false},
+	}
+	for _, tt := range tests {
+		if got := IsAllowed(user, Requirement{Path: tt.path, Country: "TH"}); got != tt.want {
+			t.Errorf("IsAllowed(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPathInheritanceAcrossRoles(t *testing.T) {
+	// A subtree exception denies at its priority even when another role grants the subtree.
+	user := userFromRoles("alice", []Role{
+		{RoleID: "hr", Permissions: []Permission{{Path: "hr:**", Countries: []string{"TH"}, ExceptPaths: []string{"hr:payroll:**"}}}},
+		{RoleID: "payroll", Permissions: []Permission{{Path: "hr:payroll:view", Countries: []string{"TH"}}}},
+	})
+	d := Decide(user, Requirement{Path: "hr:payroll:view", Country: "TH"})
+	if d.Allowed || d.Reason != decisionPathExcluded || d.RoleID != "hr" {
+		t.Fatalf("Decide = %+v, want path_excluded by role hr", d)
+	}
+}
+
+/*
+benchmarkUser holds many country-scoped permissions and a single global one, the shape of a
+typical admin with regional grants in several departments.
+*/
+func benchmarkUser() *User {
+	var perms []Permission
+	for _, dept := range []string{"hr", "finance", "sales", "ops", "legal", "it", "support", "marketing"} {
+		perms = append(perms,
+			Permission{Path: dept + ":profile:*", Regions: []string{"ASIA", "EUROPE"}, ExceptCountries: []string{"CN"}},
+			Permission{Path: dept + ":reports:report_*", Regions: []string{"NORTH_AMERICA"}, ExceptRegions: []string{"AFRICA"}},
+			Permission{Path: dept + ":**", Countries: []string{"TH", "SG", "VN"}, ExceptPaths: []string{dept + ":payroll:**"}},
+		)
+	}
+	perms = append(perms, Permission{Path: "audit:*:read", Regions: []string{"GLOBAL"}})
+	return userFromRoles("bench", []Role{{RoleID: "admin", Permissions: perms}})
+}
+
+func BenchmarkDecide(b *testing.B) {
+	user := benchmarkUser()
+	for _, bc := range []struct {
+		name string
+		req  Requirement
+	}{
+		{"global", Requirement{Path: "audit:logs:read", Country: "GLOBAL"}},
+		{"global_denied", Requirement{Path: "marketing:campaigns:view", Country: "GLOBAL"}},
+		{"country", Requirement{Path: "marketing:campaigns:view", Country: "TH"}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Decide(user, bc.req)
+			}
+		})
+	}
+}
+
+func TestDecideGlobalRequirement(t *testing.T) {
+	user := benchmarkUser()
+	if d := Decide(user, Requirement{Path: "audit:logs:read", Country: "GLOBAL"}); !d.Allowed {
+		t.Errorf("GLOBAL grant denied: %s", d)
+	}
+	if d := Decide(user, Requirement{Path: "hr:profile:view", Country: "GLOBAL"}); d.Allowed || d.Reason != decisionNoMatchingPermission {
+		t.Errorf("regional grant decided a GLOBAL requirement: %s", d)
+	}
+	// Path exclusions of non-global rules still deny a GLOBAL requirement.
+	if d := Decide(user, Requirement{Path: "hr:payroll:view", Country: "GLOBAL"}); d.Reason != decisionPathExcluded {
+		t.Errorf("Decide = %s, want path_excluded", d)
+	}
+}
+
+func TestExtractUserFromUnsignedToken(t *testing.T) {
+	useCachedRoles(t, Role{RoleID: "viewer", Permissions: []Permission{
+		{Path: "hr:profile:view", Countries: []string{"TH"}},
+	}})
+	token, err := testutil.UnsignedToken(testutil.TokenSpec{Username: "alice", Subject: "alice-id", Roles: []string{"viewer"}})
+	if err != nil {
+		t.Fatalf("UnsignedToken: %v", err)
+	}
+	claims, err := parseTokenString(token)
+	if err != nil {
+		t.Fatalf("parseTokenString: %v", err)
+	}
+	user, err := extractUser(claims)
+	if err != nil {
+		t.Fatalf("extractUser: %v", err)
+	}
+	if user.ID != "alice" || user.Subject != "alice-id" {
+		t.Fatalf("user = %s (%s), want alice (alice-id)", user.ID, user.Subject)
+	}
+	if !IsAllowed(user, Requirement{Path: "hr:profile:view", Country: "TH"}) {
+		t.Error("viewer role not applied to the token's user")
+	}
+}
+
+func TestMatchPathCustomSeparator(t *testing.T) {
+	pathSeparator = "/"
+	t.Cleanup(func() { pathSeparator = ":" })
+	tests := []struct {
+		pattern, target string
+		want            bool
+	}{
+		{"hr/profile/view", "hr/profile/view", true},
+		{"hr/*/view", "hr/profile/view", true},
+		{"hr/**", "hr/payroll/bonus/view", true},
+		{"hr/report_*", "hr/report_2024", true},
+		{"hr/*", "hr/profile/view", false},
+		// With "/" as the separator ":" is an ordinary character, so "hr:*" is an in-segment glob.
+		{"hr:profile:view", "hr:profile:view", true},
+		{"hr:*", "hr:profile", true},
+		{"hr:*", "hr:profile/view", false},
+		{"hr/*", "hr:profile", false},
+	}
+	for _, tt := range tests {
+		if got := matchPath(tt.pattern, tt.target); got != tt.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tt.pattern, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestDecidePriority(t *testing.T) {
+	grant := func(priority int) Permission {
+		return Permission{Path: "hr:payroll:view", Countries: []string{"TH"}, Priority: priority}
+	}
+	exclude := func(priority int) Permission {
+		return Permission{Path: "hr:**", Countries: []string{"TH"}, ExceptPaths: []string{"hr:payroll:**"}, Priority: priority}
+	}
+	tests := []struct {
+		name     string
+		perms    []Permission
+		allowed  bool
+		decision string
+	}{
+		{"deny wins at equal priority", []Permission{grant(0), exclude(0)}, false, decisionPathExcluded},
+		{"deny wins at equal priority in either order", []Permission{exclude(5), grant(5)}, false, decisionPathExcluded},
+		{"higher allow beats lower deny", []Permission{exclude(1), grant(10)}, true, decisionAllowed},
+		{"higher deny beats lower allow", []Permission{grant(1), exclude(10)}, false, decisionPathExcluded},
+		{"negative priority deny loses to default allow", []Permission{exclude(-1), grant(0)}, true, decisionAllowed},
+	}
+	for _, tt := range tests {
+		// Split the rules across roles: role order must not matter either.
+		var roles []Role
+		for i, perm := range tt.perms {
+			roles = append(roles, Role{RoleID: fmt.Sprintf("role-%d", i), Permissions: []Permission{perm}})
+		}
+		d := Decide(userFromRoles("alice", roles), Requirement{Path: "hr:payroll:view", Country: "TH"})
+		if d.Allowed != tt.allowed || d.Reason != tt.decision {
+			t.Errorf("%s: Decide = %s, want %s", tt.name, d, tt.decision)
+		}
+	}
+}
+
+func TestDecidePriorityPicksHighestAllow(t *testing.T) {
+	user := userFromRoles("alice", []Role{
+		{RoleID: "low", Permissions: []Permission{{Path: "hr:**", Countries: []string{"TH"}}}},
+		{RoleID: "high", Permissions: []Permission{{Path: "hr:payroll:*", Countries: []string{"TH"}, Priority: 3}}},
+	})
+	d := Decide(user, Requirement{Path: "hr:payroll:view", Country: "TH"})
+	if !d.Allowed || d.RoleID != "high" || d.MatchedPermission.Priority != 3 {
+		t.Fatalf("Decide = %s, want allowed by role high", d)
+	}
+}
+
+func TestAddRoleCountriesUsesPrecomputedSet(t *testing.T) {
+	role := Role{RoleID: "asia", Permissions: []Permission{{Path: "hr:**", Regions: []string{"ASIA"}}}}
+	role.countries = roleCountrySet(role)
+	if _, ok := role.countries["TH"]; !ok || len(role.countries) != len(regionMap()["ASIA"]) {
+		t.Fatalf("roleCountrySet = %d countries, want ASIA", len(role.countries))
+	}
+
+	// A set that differs from the permissions shows which one addRoleCountries reads.
+	role.countries = map[string]struct{}{"JP": {}}
+	set := make(map[string]struct{})
+	addRoleCountries(set, role)
+	if len(set) != 1 {
+		t.Fatalf("countries = %v, want only the precomputed JP", set)
+	}
+
+	// Roles built in memory carry no set and are expanded on the fly.
+	role.countries = nil
+	set = make(map[string]struct{})
+	addRoleCountries(set, role)
+	if _, ok := set["TH"]; !ok {
+		t.Fatalf("countries = %v, want the expanded ASIA region", set)
+	}
+}
+
+func TestPrecomputedCountriesInvalidatedOnReload(t *testing.T) {
+	role := Role{RoleID: "asia", Permissions: []Permission{{Path: "hr:**", Regions: []string{"ASIA"}}}}
+	role.countries = map[string]struct{}{"JP": {}}
+	useCachedRoles(t, role)
+	user, err := extractUser(jwt.MapClaims{"preferred_username": "alice", "roles": []interface{}{"asia"}})
+	if err != nil {
+		t.Fatalf("extractUser: %v", err)
+	}
+	if len(user.AllowedCountries) != 1 || user.AllowedCountries[0] != "JP" {
+		t.Fatalf("allowed countries = %v, want the cached [JP]", user.AllowedCountries)
+	}
+
+	// Editing the role and reloading regions both drop the cached role with its set.
+	roleCaches.invalidate("asia")
+	if _, ok := roleCaches.get("asia"); ok {
+		t.Error("invalidate kept the cached role and its country set")
+	}
+	roleCaches.set("asia", role)
+	roleCaches.clear()
+	if _, ok := roleCaches.get("asia"); ok {
+		t.Error("clear kept the cached role and its country set")
+	}
+}
+
+/*
+benchmarkRoles are region-heavy roles, the case where precomputed country sets pay off.
+*/
+func benchmarkRoles(precompute bool) []Role {
+	var roles []Role
+	for r := 0; r < 5; r++ {
+		role := Role{RoleID: fmt.Sprintf("role-%d", r)}
+		for p := 0; p < 20; p++ {
+			role.Permissions = append(role.Permissions, Permission{
+				Path: fmt.Sprintf("dept%d:res%d:*", r, p), Regions: []string{"ASIA", "EUROPE", "AFRICA"},
+			})
+		}
+		if precompute {
+			role.countries = roleCountrySet(role)
+		}
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+func BenchmarkUserCountries(b *testing.B) {
+	for _, bc := range []struct {
+		name       string
+		precompute bool
+	}{{"expanded", false}, {"precomputed", true}} {
+		roles := benchmarkRoles(bc.precompute)
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				set := make(map[string]struct{})
+				for _, role := range roles {
+					addRoleCountries(set, role)
+				}
+				countryList(set)
+			}
+		})
+	}
+}
+
+func TestDecideReasons(t *testing.T) {
+	user := userFromRoles("alice", []Role{{RoleID: "hr", Permissions: []Permission{
+		{Path: "hr:**", Countries: []string{"TH"}, ExceptPaths: []string{"hr:payroll:**"}},
+	}}})
+	tests := []struct {
+		req    Requirement
+		reason string
+		text   string
+	}{
+		{Requirement{Path: "hr:profile:view", Country: "TH"}, decisionAllowed, ""},
+		{Requirement{Path: "hr:profile:view", Country: "US"}, decisionCountryNotAllowed, "permission denied: country_not_allowed"},
+		{Requirement{Path: "hr:payroll:view", Country: "TH"}, decisionPathExcluded, "permission denied: path_excluded by hr:** (role hr)"},
+		{Requirement{Path: "finance:ledger:view", Country: "TH"}, decisionNoMatchingPermission, "permission denied: no_matching_permission"},
+	}
+	for _, tt := range tests {
+		d := Decide(user, tt.req)
+		if d.Reason != tt.reason || d.Allowed != (tt.reason == decisionAllowed) || IsAllowed(user, tt.req) != d.Allowed {
+			t.Errorf("Decide(%s) = %+v, want reason %s", tt.req.Path, d, tt.reason)
+		}
+		err := d.Err()
+		if tt.text == "" {
+			if err != nil {
+				t.Errorf("Decide(%s).Err() = %v, want nil", tt.req.Path, err)
+			}
+			continue
+		}
+		var denial *DenialError
+		if !errors.As(err, &denial) || denial.Decision.Reason != tt.reason {
+			t.Errorf("Decide(%s).Err() = %v, want a *DenialError with reason %s", tt.req.Path, err, tt.reason)
+			continue
+		}
+		if err.Error() != tt.text {
+			t.Errorf("Decide(%s).Err() = %q, want %q", tt.req.Path, err, tt.text)
+		}
+	}
+}
+
+func TestDecideReportsDecidingRule(t *testing.T) {
+	user := userFromRoles("alice", []Role{{RoleID: "hr", Permissions: []Permission{
+		{Path: "hr:**", Countries: []string{"TH"}, ExceptPaths: []string{"hr:payroll:**"}, Description: "HR staff"},
+	}}})
+	allowed := Decide(user, Requirement{Path: "hr:profile:view", Country: "TH"})
+	if allowed.MatchedPermission == nil || allowed.MatchedPermission.Description != "HR staff" || allowed.DeniedBy != nil {
+		t.Errorf("allowed decision = %+v, want the matched hr:** rule", allowed)
+	}
+	denied := Decide(user, Requirement{Path: "hr:payroll:view", Country: "TH"})
+	if denied.DeniedBy == nil || denied.DeniedBy.Path != "hr:**" || denied.MatchedPermission != nil {
+		t.Errorf("denied decision = %+v, want DeniedBy hr:**", denied)
+	}
+}
+
+func TestSegmentMatch(t *testing.T) {
+	tests := []struct {
+		pattern, segment string
+		want             bool
+	}{
+		{"*", "anything", true},
+		{"*", "", true},
+		{"user-*", "user-42", true},
+		{"user-*", "user-", true},
+		{"user-*", "admin-42", false},
+		{"user-*", "user", false},
+		{"*.json", "report.json", true},
+		{"*.json", ".json", true},
+		{"*.json", "report.jsonx", false},
+		{"report_*_q1", "report_2024_q1", true},
+		{"report_*_q1", "report_q1", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "acb", false},
+		{"*_*", "a_b", true},
+		{"User-*", "user-42", true},
+		{"view", "view", true},
+		{"view", "edit", false},
+		// "**" is only special as a whole segment in matchPath; inside a segment it is a glob.
+		{"**", "anything", true},
+	}
+	for _, tt := range tests {
+		if got := segmentMatch(tt.pattern, tt.segment); got != tt.want {
+			t.Errorf("segmentMatch(%q, %q) = %v, want %v", tt.pattern, tt.segment, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPathPartialWildcards(t *testing.T) {
+	tests := []struct {
+		pattern, target string
+		want            bool
+	}{
+		{"reports:report_2024_*", "reports:report_2024_q1", true},
+		{"reports:report_2024_*", "reports:report_2023_q1", false},
+		{"files:*.json", "files:data.json", true},
+		{"files:*.json", "files:dir:data.json", false},
+		{"files:**", "files:dir:data.json", true},
+		{"files:**:*.json", "files:a:b:data.json", true},
+		{"files:**:*.json", "files:data.csv", false},
+		{"users:user-*:view", "users:user-7:view", true},
+		{"users:user-*:view", "users:user-7:edit", false},
+	}
+	for _, tt := range tests {
+		if got := matchPath(tt.pattern, tt.target); got != tt.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tt.pattern, tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// manifest.go
+//
+// Routes declared in a YAML manifest instead of Go code, so a permission change is a
+// reviewed edit to one file rather than a deploy. ROUTE_MANIFEST_FILE lists each route's
+// method, path, handler name, and Requirement:
+//
+//	routes:
+//	  - method: GET
+//	    path: /geo/:code/regions
+//	    handler: country_regions
+//	    requirement: {path: "geo:regions:view", country: "param:code"}
+//	  - method: GET
+//	    path: /ops/version
+//	    handler: version
+//	    access: authenticated
+//
+// Handlers stay in code and are referenced by the names in namedHandlers. The manifest is
+// loaded once at startup, and a route naming an unknown handler or an invalid Requirement
+// stops the service.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// routeManifest is the document in ROUTE_MANIFEST_FILE.
+type routeManifest struct {
+	Routes []manifestRoute `yaml:"routes"`
+}
+
+// manifestRoute is one manifest entry. Access is "protected" (the default, which needs a
+// Requirement), "authenticated", or "public". OwnerParam sets Requirement.OwnerParam.
+type manifestRoute struct {
+	Method      string       `yaml:"method"`
+	Path        string       `yaml:"path"`
+	Handler     string       `yaml:"handler"`
+	Access      string       `yaml:"access,omitempty"`
+	OwnerParam  string       `yaml:"owner_param,omitempty"`
+	Requirement *routePolicy `yaml:"requirement,omitempty"`
+}
+
+// namedHandlers are the handlers a manifest may reference, by name.
+var namedHandlers = map[string]fiber.Handler{
+	"capabilities":    capabilitiesHandler,
+	"bulk_check":      bulkCheckHandler,
+	"country_regions": countryRegionsHandler,
+	"regions":         regionsHandler,
+	"debug_config":    debugConfigHandler,
+	"debug_keycloak":  keycloakDebugHandler,
+	"audit_export":    auditExportHandler,
+	"version":         versionHandler,
+}
+
+// manifestMethods are the HTTP methods a manifest route may use.
+var manifestMethods = map[string]bool{
+	fiber.MethodGet: true, fiber.MethodPost: true, fiber.MethodPut: true,
+	fiber.MethodPatch: true, fiber.MethodDelete: true,
+}
+
+/*
+loadRouteManifest registers the routes of ROUTE_MANIFEST_FILE on the router. It does nothing
+when the variable is unset. The router still refuses duplicates of code-defined routes and
+public routes outside PUBLIC_PATHS.
+*/
+func loadRouteManifest(router *Router) {
+	path := os.Getenv("ROUTE_MANIFEST_FILE")
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read ROUTE_MANIFEST_FILE %s: %v", path, err)
+	}
+	var manifest routeManifest
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	// A misspelled key would otherwise drop part of a Requirement without notice.
+	dec.KnownFields(true)
+	if err := dec.Decode(&manifest); err != nil {
+		log.Fatalf("Invalid ROUTE_MANIFEST_FILE %s: %v", path, err)
+	}
+	for i, route := range manifest.Routes {
+		if err := registerManifestRoute(router, route); err != nil {
+			log.Fatalf("Invalid ROUTE_MANIFEST_FILE %s: route %d (%s %s): %v", path, i, route.Method, route.Path, err)
+		}
+	}
+	logInfo("registered manifest routes", "count", len(manifest.Routes), "source", path)
+}
+
+/*
+registerManifestRoute validates one manifest entry and registers it with the guard its
+access level calls for.
+*/
+func registerManifestRoute(router *Router, route manifestRoute) error {
+	method := strings.ToUpper(route.Method)
+	if !manifestMethods[method] {
+		return fmt.Errorf("unsupported method %q", route.Method)
+	}
+	if !strings.HasPrefix(route.Path, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	handler, ok := namedHandlers[route.Handler]
+	if !ok {
+		return fmt.Errorf("unknown handler %q", route.Handler)
+	}
+
+	switch route.Access {
+	case "", string(accessProtected):
+		if route.Requirement == nil {
+			return fmt.Errorf("protected route has no requirement")
+		}
+		req, err := route.Requirement.requirement()
+		if err != nil {
+			return fmt.Errorf("requirement: %v", err)
+		}
+		var params []string
+		if param, ok := strings.CutPrefix(req.Country, CountryParamPrefix); ok {
+			params = append(params, param)
+		}
+		if route.OwnerParam != "" {
+			params = append(params, route.OwnerParam)
+		}
+		for _, param := range params {
+			if !hasRouteParam(route.Path, param) {
+				return fmt.Errorf("parameter %q is not in the path", param)
+			}
+		}
+		req.OwnerParam = route.OwnerParam
+		router.Protected(method, route.Path, req, handler)
+	case string(accessAuthenticated), string(accessPublic):
+		if route.Requirement != nil || route.OwnerParam != "" {
+			return fmt.Errorf("%s route cannot have a requirement", route.Access)
+		}
+		if route.Access == string(accessPublic) {
+			router.Public(method, route.Path, handler)
+		} else {
+			router.Authenticated(method, route.Path, handler)
+		}
+	default:
+		return fmt.Errorf("access %q must be protected, authenticated, or public", route.Access)
+	}
+	return nil
+}
+
+/*
+hasRouteParam reports whether the Fiber route path declares the ":name" parameter.
+*/
+func hasRouteParam(path, name string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		if strings.TrimSuffix(segment, "?") == ":"+name {
+			return true
+		}
+	}
+	return false
+}
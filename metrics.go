@@ -0,0 +1,77 @@
+// metrics.go
+//
+// Minimal Prometheus-compatible metrics exposition. Subsystems register counters and
+// gauges backed by callbacks, and the /metrics endpoint renders them in the Prometheus
+// text format without pulling in the full client library.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// metric is a single registered series rendered by the /metrics endpoint.
+type metric struct {
+	name  string
+	help  string
+	kind  string // "counter" or "gauge"
+	value func() float64
+}
+
+var (
+	metricsMu       sync.Mutex
+	metricsRegistry []metric
+)
+
+/*
+registerCounter adds a monotonically increasing counter to the metrics registry.
+The callback is evaluated on every scrape.
+*/
+func registerCounter(name, help string, value func() uint64) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metricsRegistry = append(metricsRegistry, metric{
+		name:  name,
+		help:  help,
+		kind:  "counter",
+		value: func() float64 { return float64(value()) },
+	})
+}
+
+/*
+registerGauge adds a point-in-time gauge to the metrics registry.
+The callback is evaluated on every scrape.
+*/
+func registerGauge(name, help string, value func() float64) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metricsRegistry = append(metricsRegistry, metric{
+		name:  name,
+		help:  help,
+		kind:  "gauge",
+		value: value,
+	})
+}
+
+/*
+metricsHandler renders all registered metrics in the Prometheus text exposition format.
+*/
+func metricsHandler(c *fiber.Ctx) error {
+	metricsMu.Lock()
+	registered := make([]metric, len(metricsRegistry))
+	copy(registered, metricsRegistry)
+	metricsMu.Unlock()
+
+	var b strings.Builder
+	for _, m := range registered {
+		fmt.Fprintf(&b, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", m.name, m.kind)
+		fmt.Fprintf(&b, "%s %g\n", m.name, m.value())
+	}
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	return c.SendString(b.String())
+}
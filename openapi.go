@@ -0,0 +1,343 @@
+// openapi.go
+//
+// OpenAPI 3 description of the service, served at GET /openapi.json. Paths and their
+// security come from the Router's route table, so every registered route is listed with
+// the Requirement guarding it; request and response schemas are derived from the Go types
+// the handlers encode, so the contract cannot drift from the code.
+
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiDoc describes one operation. Request and Response are zero values of the body types;
+// nil means no JSON body is documented.
+type apiDoc struct {
+	summary  string
+	request  interface{}
+	response interface{}
+}
+
+// apiDocs documents the operations by "METHOD path" as registered with the Router. Routes
+// without an entry are still listed, with a generic JSON response.
+var apiDocs = map[string]apiDoc{
+	"GET /public":                     {summary: "Public example endpoint"},
+	"GET /metrics":                    {summary: "Prometheus metrics in text format"},
+	"GET /healthz":                    {summary: "Health of MongoDB, regions, and the role cache"},
+	"GET /version":                    {summary: "Build metadata", response: VersionResponse{}},
+	"POST /authorize":                 {summary: "Policy decision for a token and requirement, without enforcing it", request: authorizeRequest{}, response: accessDecision{}},
+	"GET /openapi.json":               {summary: "This OpenAPI document"},
+	"GET /user":                       {summary: "The caller's username and allowed countries", response: UserSummaryResponse{}},
+	"GET /user/profile":               {summary: "The caller's roles and the role that granted access", response: ProfileResponse{}},
+	"GET /rbac/capabilities":          {summary: "The caller's effective capabilities (ETag-aware)", response: CapabilitiesResponse{}},
+	"POST /rbac/bulk-check":           {summary: "Check many paths in one country", request: bulkCheckRequest{}, response: map[string]bool{}},
+	"GET /rbac/country/:code/regions": {summary: "Regions containing a country", response: CountryRegionsResponse{}},
+	"GET /admin/regions":              {summary: "Effective region mapping", response: map[string][]string{}},
+	"POST /admin/tokens/revoke":       {summary: "Revoke a token by jti", request: revokeRequest{}, response: RevokedToken{}},
+	"GET /admin/exclusions":           {summary: "Active global path exclusions", response: ExclusionListResponse{}},
+	"POST /admin/exclusions":          {summary: "Add a global path exclusion", request: addExclusionRequest{}, response: PathExclusion{}},
+	"DELETE /admin/exclusions":        {summary: "Remove the global path exclusion in ?path="},
+	"POST /admin/exclusions/reload":   {summary: "Reload global path exclusions from MongoDB", response: ExclusionListResponse{}},
+	"PUT /admin/roles/:id":            {summary: "Create or replace a role", request: Role{}, response: Role{}},
+	"POST /admin/roles/:id/simulate":  {summary: "What-if analysis of a proposed role change", request: simulateRequest{}, response: SimulateResponse{}},
+	"POST /admin/access/diff":         {summary: "Compare two users' access", request: diffAccessRequest{}, response: AccessDiffResponse{}},
+	"GET /admin/debug/keycloak":       {summary: "Keycloak discovery and JWKS reachability"},
+	"GET /admin/debug/config":         {summary: "Effective runtime RBAC configuration"},
+	"GET /admin/audit/export":         {summary: "Export of every role's effective permissions (JSON or CSV)"},
+	"GET /hr/payroll/audit":           {summary: "Payroll audit (composite requirement)"},
+	"GET /ws/notifications":           {summary: "Notifications websocket (upgrade handshake)"},
+	"GET /user/payroll":               {summary: "Payroll in Thailand"},
+	"GET /admin/items":                {summary: "Item count"},
+}
+
+// requirementDoc is the documented shape of a Requirement, listed on protected operations
+// as x-rbac-requirements.
+type requirementDoc struct {
+	Path          string   `json:"path"`
+	Country       string   `json:"country"`
+	MinAcr        string   `json:"min_acr,omitempty"`
+	RequiredAmr   []string `json:"required_amr,omitempty"`
+	MaxTokenAge   string   `json:"max_token_age,omitempty"`
+	OwnerParam    string   `json:"owner_param,omitempty"`
+	Audience      string   `json:"audience,omitempty"`
+	Feature       string   `json:"feature,omitempty"`
+	DenyMessage   string   `json:"deny_message,omitempty"`
+	RequiredRoles []string `json:"required_roles,omitempty"`
+}
+
+/*
+newRequirementDoc converts a Requirement to its documented shape.
+*/
+func newRequirementDoc(req Requirement) requirementDoc {
+	doc := requirementDoc{
+		Path:          req.Path,
+		Country:       req.Country,
+		MinAcr:        req.MinAcr,
+		RequiredAmr:   req.RequiredAmr,
+		OwnerParam:    req.OwnerParam,
+		Audience:      req.Audience,
+		Feature:       req.Feature,
+		DenyMessage:   req.DenyMessage,
+		RequiredRoles: req.RequiredRoles,
+	}
+	if req.MaxTokenAge > 0 {
+		doc.MaxTokenAge = req.MaxTokenAge.String()
+	}
+	return doc
+}
+
+// schemaEnums lists the allowed values of documented string fields, by schema and property.
+var schemaEnums = map[string]map[string][]string{
+	"ErrorResponse": {"code": errorCodes},
+	"AccessDecision": {"reason": {
+		reasonAllowed, reasonInvalidRequest, reasonInvalidToken, reasonUserResolutionFailed,
+		reasonCountryUnresolved, reasonPermissionDenied, reasonRoleRequired, reasonStepUpRequired, reasonReauthRequired,
+		reasonFeatureDisabled, reasonLegallyRestricted, reasonNoRouteMapping,
+	}},
+}
+
+// schemaBuilder collects component schemas while converting Go types.
+type schemaBuilder struct {
+	components map[string]interface{}
+}
+
+/*
+schemaFor returns the JSON schema of t. Named structs become components referenced by $ref.
+*/
+func (b *schemaBuilder) schemaFor(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schemaFor(t.Elem())}
+	case reflect.Struct:
+		name := schemaName(t)
+		if _, seen := b.components[name]; !seen {
+			// Reserve the name first so recursive types terminate.
+			b.components[name] = nil
+			b.components[name] = b.structSchema(name, t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	// interface{} and anything else: any JSON value.
+	return map[string]interface{}{}
+}
+
+/*
+structSchema lists the JSON fields of a struct. Fields without omitempty are required.
+*/
+func (b *schemaBuilder) structSchema(name string, t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		fieldName, opts, _ := strings.Cut(tag, ",")
+		if fieldName == "" {
+			fieldName = field.Name
+		}
+		prop := b.schemaFor(field.Type)
+		if values, ok := schemaEnums[name][fieldName]; ok {
+			prop["enum"] = values
+		}
+		properties[fieldName] = prop
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, fieldName)
+		}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+/*
+schemaName is the component name of a struct type, capitalized for unexported types.
+*/
+func schemaName(t reflect.Type) string {
+	name := t.Name()
+	if name == "" {
+		return "Object"
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+/*
+openAPIPath converts a Fiber route to an OpenAPI path and its parameter names: ":id"
+becomes "{id}" and a trailing "*" becomes "{wildcard}".
+*/
+func openAPIPath(route string) (string, []string) {
+	var params []string
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			params = append(params, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		case segment == "*":
+			params = append(params, "wildcard")
+			segments[i] = "{wildcard}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+/*
+operationID derives a stable operation id such as "get_admin_roles_id" from a route.
+*/
+func operationID(method, route string) string {
+	id := strings.ToLower(method)
+	for _, segment := range strings.Split(route, "/") {
+		segment = strings.Trim(segment, ":*")
+		if segment == "" {
+			continue
+		}
+		id += "_" + strings.NewReplacer(".", "_", "-", "_").Replace(segment)
+	}
+	return id
+}
+
+/*
+jsonContent wraps a schema as an application/json media type.
+*/
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+/*
+OpenAPI builds the OpenAPI 3 document for the routes registered so far. HEAD routes are
+omitted since they mirror GET.
+*/
+func (r *Router) OpenAPI() map[string]interface{} {
+	b := &schemaBuilder{components: make(map[string]interface{})}
+	errorRef := b.schemaFor(reflect.TypeOf(ErrorResponse{}))
+	b.schemaFor(reflect.TypeOf(requirementDoc{}))
+	errorResponse := func(description string) map[string]interface{} {
+		return map[string]interface{}{"description": description, "content": jsonContent(errorRef)}
+	}
+
+	keys := make([]string, 0, len(r.routes))
+	for key, entry := range r.routes {
+		if entry.method != fiber.MethodHead {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	paths := make(map[string]interface{})
+	for _, key := range keys {
+		entry := r.routes[key]
+		doc, ok := apiDocs[key]
+		if !ok && entry.access == accessMapped {
+			doc.summary = "Envoy ext_authz check via the route-to-permission table"
+		}
+		path, params := openAPIPath(entry.path)
+
+		success := map[string]interface{}{"description": "OK"}
+		if doc.response != nil {
+			success["content"] = jsonContent(b.schemaFor(reflect.TypeOf(doc.response)))
+		} else {
+			success["content"] = jsonContent(map[string]interface{}{"type": "object"})
+		}
+		responses := map[string]interface{}{"200": success}
+		op := map[string]interface{}{
+			"summary":       doc.summary,
+			"operationId":   operationID(entry.method, entry.path),
+			"responses":     responses,
+			"x-rbac-access": entry.access,
+		}
+		if len(params) > 0 {
+			parameters := make([]interface{}, len(params))
+			for i, name := range params {
+				parameters[i] = map[string]interface{}{
+					"name": name, "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+				}
+			}
+			op["parameters"] = parameters
+		}
+		if doc.request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(b.schemaFor(reflect.TypeOf(doc.request))),
+			}
+			responses["400"] = errorResponse("Malformed request")
+		}
+		if entry.access != accessPublic {
+			op["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+			responses["401"] = errorResponse("Missing or invalid token")
+			responses["403"] = errorResponse("Access denied; see code")
+		}
+		switch entry.access {
+		case accessProtected:
+			op["x-rbac-requirements"] = []requirementDoc{newRequirementDoc(entry.requirement)}
+		case accessComposite:
+			reqs := make([]requirementDoc, len(entry.requirements))
+			for i, req := range entry.requirements {
+				reqs[i] = newRequirementDoc(req)
+			}
+			op["x-rbac-requirements"] = reqs
+			op["x-rbac-mode"] = entry.mode
+		}
+
+		item, _ := paths[path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[strings.ToLower(entry.method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "fiber-demo RBAC service",
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+}
+
+/*
+openAPIHandler serves the router's OpenAPI document. It is built on the first request,
+once every route is registered.
+*/
+func openAPIHandler(r *Router) fiber.Handler {
+	var once sync.Once
+	var spec map[string]interface{}
+	return func(c *fiber.Ctx) error {
+		once.Do(func() { spec = r.OpenAPI() })
+		return c.JSON(spec)
+	}
+}
@@ -0,0 +1,105 @@
+// overrides.go
+//
+// Optional per-user country overrides from the MongoDB "user_overrides" collection, keyed
+// by username, for one-off exceptions without bespoke roles:
+//
+//	{"username": "alice", "add_countries": ["VN"], "remove_countries": ["TH"]}
+//
+// Added countries extend the country scope of the user's grants; removed countries are
+// denied for every non-GLOBAL requirement and win over both roles and additions.
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UserOverride is a user_overrides document.
+type UserOverride struct {
+	Username        string   `bson:"username" json:"username"`
+	AddCountries    []string `bson:"add_countries" json:"add_countries,omitempty"`
+	RemoveCountries []string `bson:"remove_countries" json:"remove_countries,omitempty"`
+}
+
+// userOverridesEnabled turns on the per-request user_overrides lookup (USER_OVERRIDES_ENABLED).
+var userOverridesEnabled bool
+
+/*
+initUserOverrides reads USER_OVERRIDES_ENABLED.
+*/
+func initUserOverrides() {
+	if v := os.Getenv("USER_OVERRIDES_ENABLED"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid USER_OVERRIDES_ENABLED %q: expected true or false", v)
+		}
+		userOverridesEnabled = b
+	}
+	if userOverridesEnabled {
+		logInfo("per-user country overrides enabled", "collection", "user_overrides")
+	}
+}
+
+/*
+loadUserOverride returns the override document for username, or nil when there is none.
+*/
+func loadUserOverride(ctx context.Context, username string) (*UserOverride, error) {
+	var override UserOverride
+	err := findRoleDocument(ctx, "user_overrides", bson.M{"username": username}, &override)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &override, nil
+}
+
+/*
+overrideCountries uppercases the override's country codes, dropping (and logging) anything
+that is not a known ISO-2 code so a typo can never act as a wildcard.
+*/
+func overrideCountries(username string, codes []string) []string {
+	var out []string
+	for _, code := range codes {
+		code = normalizeCountry(code)
+		if !isKnownCountry(code) {
+			logWarn("ignoring invalid override country", "user", logSafe(username), "country", code)
+			continue
+		}
+		out = append(out, code)
+	}
+	return out
+}
+
+/*
+applyUserOverride merges the override into the user's country scope: additions are added to
+AllowedCountries and removals are taken out and remembered so Decide denies them.
+*/
+func applyUserOverride(user *User, override *UserOverride) {
+	user.addedCountries = overrideCountries(user.ID, override.AddCountries)
+	user.removedCountries = overrideCountries(user.ID, override.RemoveCountries)
+	if len(user.addedCountries) == 0 && len(user.removedCountries) == 0 {
+		return
+	}
+
+	set := make(map[string]struct{}, len(user.AllowedCountries)+len(user.addedCountries))
+	for _, c := range user.AllowedCountries {
+		set[c] = struct{}{}
+	}
+	for _, c := range user.addedCountries {
+		set[c] = struct{}{}
+	}
+	for _, c := range user.removedCountries {
+		delete(set, c)
+	}
+	user.AllowedCountries = countryList(set)
+	logInfo("user override applied", "user", logSafe(user.ID),
+		"add_countries", user.addedCountries, "remove_countries", user.removedCountries)
+}
@@ -0,0 +1,150 @@
+// pdp.go
+//
+// Policy decision point: evaluates a token, path, and country and reports the decision
+// instead of enforcing it, for external enforcers such as KrakenD or Envoy. The same
+// evaluation backs POST /authorize and the gRPC Authorization.Check service.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Decision reason codes reported by the policy decision point.
+const (
+	reasonAllowed              = "allowed"
+	reasonInvalidRequest       = "invalid_request"
+	reasonInvalidToken         = "invalid_token"
+	reasonUserResolutionFailed = "user_resolution_failed"
+	reasonCountryUnresolved    = "country_unresolved"
+	reasonPermissionDenied     = "permission_denied"
+	reasonRoleRequired         = "role_required"
+	reasonStepUpRequired       = "step_up_required"
+	reasonReauthRequired       = "reauth_required"
+	reasonFeatureDisabled      = "feature_disabled"
+	reasonLegallyRestricted    = "legally_restricted"
+	reasonNoRouteMapping       = "no_route_mapping"
+)
+
+// accessDecision is the outcome of evaluateAccess. Description is the deciding permission's
+// description, when it has one.
+type accessDecision struct {
+	Allowed     bool   `json:"allowed"`
+	Reason      string `json:"reason"`
+	Detail      string `json:"detail,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+/*
+evaluateAccess runs the middleware pipeline without a request context: parse and verify the
+token, build the user from their roles, resolve FROM_TOKEN, evaluate IsAllowed, and check
+required roles and step-up requirements. Every outcome, including a bad token, is returned as a decision. The
+HTTP-only decision hook is not consulted.
+*/
+func evaluateAccess(token string, req Requirement) accessDecision {
+	// The prefix is matched before normalizing, which would uppercase it, as routePolicy does.
+	if strings.HasPrefix(req.Country, CountryParamPrefix) {
+		return accessDecision{Reason: reasonInvalidRequest, Detail: "route parameter countries are not supported here"}
+	}
+	req.Country = normalizeCountry(req.Country)
+	if err := validateRequirement(req); err != nil {
+		return accessDecision{Reason: reasonInvalidRequest, Detail: err.Error()}
+	}
+	claims, err := parseTokenString(strings.TrimPrefix(token, "Bearer "))
+	if err != nil {
+		return accessDecision{Reason: reasonInvalidToken, Detail: err.Error()}
+	}
+	user, err := extractUser(claims)
+	if err != nil {
+		return accessDecision{Reason: reasonUserResolutionFailed, Detail: err.Error()}
+	}
+	if req.Country == CountryFromToken {
+		resolved, err := tokenCountry(claims)
+		if err != nil {
+			return accessDecision{Reason: reasonCountryUnresolved, Detail: err.Error()}
+		}
+		req.Country = resolved
+	}
+	decision := cachedDecide(user, req)
+	recordDecision(decision)
+	if !decision.Allowed {
+		detail := "no role grants " + req.Path + " in " + req.Country
+		switch {
+		case decision.Reason == decisionLegallyRestricted:
+			return accessDecision{Reason: reasonLegallyRestricted, Detail: req.Country + " is legally restricted"}
+		case decision.Reason == decisionGloballyExcluded:
+			detail = req.Path + " is disabled by the global exclusion " + decision.DeniedBy.Path
+		case decision.DeniedBy != nil:
+			detail = req.Path + " is excluded by the except_paths of " + decision.DeniedBy.Path
+			return accessDecision{Reason: reasonPermissionDenied, Detail: detail, Description: decision.DeniedBy.Description}
+		}
+		return accessDecision{Reason: reasonPermissionDenied, Detail: detail}
+	}
+	if missing := missingRequiredRoles(claims, req.RequiredRoles); len(missing) > 0 {
+		return accessDecision{Reason: reasonRoleRequired, Detail: "missing required roles: " + strings.Join(missing, ",")}
+	}
+	if !meetsAuthLevel(claims, req) {
+		return accessDecision{Reason: reasonStepUpRequired, Detail: "stronger authentication is required"}
+	}
+	if err := checkTokenFreshness(claims, req.MaxTokenAge); err != nil {
+		return accessDecision{Reason: reasonReauthRequired, Detail: err.Error()}
+	}
+	if req.Feature != "" && !featureEnabled(req.Feature, user) {
+		return accessDecision{Reason: reasonFeatureDisabled, Detail: "feature " + req.Feature + " is disabled"}
+	}
+	return accessDecision{Allowed: true, Reason: reasonAllowed, Description: decision.MatchedPermission.Description}
+}
+
+// authorizeRequest is the body of POST /authorize. The requirement is given either directly
+// as path and country, or as the method and route of a request to look up in the
+// route-to-permission table. Audience optionally names the target service for
+// audience-scoped permissions, overriding the mapped route's audience.
+type authorizeRequest struct {
+	Token    string `json:"token"`
+	Path     string `json:"path"`
+	Country  string `json:"country"`
+	Method   string `json:"method"`
+	Route    string `json:"route"`
+	Audience string `json:"audience"`
+}
+
+/*
+authorizeHandler answers POST /authorize with 200 and the decision for every well-formed
+request, allowed or not, including routes missing from the mapping table. Only
+transport-level problems (unparseable body, missing fields, or an unsatisfiable
+path/country) are reported as 400.
+*/
+func authorizeHandler(c *fiber.Ctx) error {
+	var body authorizeRequest
+	if err := decodeStrictJSON(c.Body(), &body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Invalid authorize body",
+			"detail": err.Error(),
+		})
+	}
+	var req Requirement
+	switch {
+	case body.Token == "":
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "token is required"})
+	case body.Path != "" && body.Country != "":
+		req = Requirement{Path: body.Path, Country: body.Country}
+	case body.Method != "" && body.Route != "":
+		route, ok := lookupRoutePolicy(body.Method, body.Route)
+		if !ok {
+			return c.JSON(accessDecision{Reason: reasonNoRouteMapping, Detail: "no permission mapping for " + body.Method + " " + body.Route})
+		}
+		req = route.requirement
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "either path and country, or method and route, are required"})
+	}
+	if body.Audience != "" {
+		req.Audience = body.Audience
+	}
+	decision := evaluateAccess(body.Token, req)
+	if decision.Reason == reasonInvalidRequest {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request", "detail": decision.Detail})
+	}
+	return c.JSON(decision)
+}
@@ -0,0 +1,16 @@
+// pdp_test.go
+//
+// Tests for the policy decision point.
+
+package main
+
+import "testing"
+
+func TestEvaluateAccessRejectsRouteParameterCountry(t *testing.T) {
+	for _, country := range []string{"param:country", "param:"} {
+		d := evaluateAccess("", Requirement{Path: "hr:profile:view", Country: country})
+		if d.Allowed || d.Reason != reasonInvalidRequest || d.Detail != "route parameter countries are not supported here" {
+			t.Errorf("evaluateAccess(%q) = %+v, want invalid request for a route parameter country", country, d)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// publicpaths.go
+//
+// Global authentication gate with a configurable set of public paths. Requests to
+// public paths bypass authentication entirely; every other request must carry a
+// parseable Bearer token before it reaches route-level RBAC checks.
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultPublicPaths keeps the built-in unauthenticated endpoints reachable when
+// PUBLIC_PATHS is not set.
+const defaultPublicPaths = "/public,/metrics,/healthz,/version,/authorize,/openapi.json"
+
+// publicPathRule is a single PUBLIC_PATHS entry.
+type publicPathRule struct {
+	path   string
+	prefix bool
+}
+
+var publicPathRules []publicPathRule
+
+/*
+initPublicPaths parses PUBLIC_PATHS, a comma-separated list of paths. An entry ending in "*"
+is a prefix match ("/docs/*" matches "/docs/" and everything below it); any other entry
+must match the request path exactly ("/healthz" does not match "/healthz/deep").
+*/
+func initPublicPaths() {
+	raw := os.Getenv("PUBLIC_PATHS")
+	if raw == "" {
+		raw = defaultPublicPaths
+	}
+	publicPathRules = parsePublicPaths(raw)
+	logInfo("public paths", "paths", raw)
+}
+
+/*
+parsePublicPaths converts the comma-separated PUBLIC_PATHS syntax into match rules,
+ignoring blank entries.
+*/
+func parsePublicPaths(raw string) []publicPathRule {
+	var rules []publicPathRule
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.HasSuffix(entry, "*") {
+			rules = append(rules, publicPathRule{path: strings.TrimSuffix(entry, "*"), prefix: true})
+		} else {
+			rules = append(rules, publicPathRule{path: entry})
+		}
+	}
+	return rules
+}
+
+/*
+isPublicPath reports whether the request path is exempt from authentication.
+*/
+func isPublicPath(path string) bool {
+	for _, rule := range publicPathRules {
+		if rule.prefix && strings.HasPrefix(path, rule.path) {
+			return true
+		}
+		if !rule.prefix && path == rule.path {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+authGate returns a global middleware that lets public paths through untouched and rejects
+any other request without a valid Bearer token. Parsed claims are stored in Locals so
+route-level middleware does not parse the token twice. Tokenless requests pass when
+anonymous access is enabled; requirePermission then evaluates them as the anonymous user.
+*/
+func authGate() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if isPublicPath(c.Path()) || isAnonymousRequest(c) {
+			return c.Next()
+		}
+		claims, err := parseToken(c)
+		if err != nil {
+			return deny(c, fiber.StatusUnauthorized, err.Error())
+		}
+		c.Locals("claims", claims)
+		return c.Next()
+	}
+}
@@ -0,0 +1,34 @@
+// publicpaths_test.go
+//
+// Tests for PUBLIC_PATHS exact and prefix matching.
+
+package main
+
+import "testing"
+
+func TestIsPublicPath(t *testing.T) {
+	previous := publicPathRules
+	t.Cleanup(func() { publicPathRules = previous })
+	publicPathRules = parsePublicPaths("/healthz, /docs/*,,/metrics")
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/healthz", true},
+		{"/healthzfoo", false},
+		{"/healthz/deep", false},
+		{"/docs/", true},
+		{"/docs/index.html", true},
+		{"/docs", false},
+		{"/documents", false},
+		{"/metrics", true},
+		{"/user/profile", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicPath(tt.path); got != tt.want {
+			t.Errorf("isPublicPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
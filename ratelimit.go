@@ -0,0 +1,69 @@
+// ratelimit.go
+//
+// Per-client rate limiting for the administrative endpoints, so runaway admin tooling
+// cannot hammer the roles collection. Regular RBAC-protected routes are not limited.
+// Responses carry X-RateLimit-Limit/Remaining/Reset headers and 429 once exhausted.
+
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+/*
+rateLimitClientKey identifies the caller for rate limiting. Behind KrakenD every request
+shares the gateway's IP, so the token subject is preferred and the resolved client IP is
+the fallback.
+*/
+func rateLimitClientKey(c *fiber.Ctx) string {
+	if claims, ok := c.Locals("claims").(jwt.MapClaims); ok {
+		if sub, _ := claims["sub"].(string); sub != "" {
+			return "sub:" + sub
+		}
+	}
+	return "ip:" + clientIP(c)
+}
+
+/*
+adminRateLimiter returns the limiter for /admin routes configured by ADMIN_RATE_LIMIT
+(requests per window, default 60; 0 disables) and ADMIN_RATE_WINDOW (default 1m).
+It returns nil when limiting is disabled.
+*/
+func adminRateLimiter() fiber.Handler {
+	max := 60
+	if v := os.Getenv("ADMIN_RATE_LIMIT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid ADMIN_RATE_LIMIT %q: must be a non-negative integer", v)
+		}
+		max = n
+	}
+	if max == 0 {
+		return nil
+	}
+	window := time.Minute
+	if v := os.Getenv("ADMIN_RATE_WINDOW"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid ADMIN_RATE_WINDOW %q: must be a positive duration such as 1m", v)
+		}
+		window = d
+	}
+	return limiter.New(limiter.Config{
+		Max:          max,
+		Expiration:   window,
+		KeyGenerator: rateLimitClientKey,
+		LimitReached: func(c *fiber.Ctx) error {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Too many admin requests, retry after the rate limit window resets.",
+			})
+		},
+	})
+}
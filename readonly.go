@@ -0,0 +1,105 @@
+// readonly.go
+//
+// Optional read-only MongoDB connection (MONGO_URI_READONLY), e.g. a secondary or replica,
+// that role lookups fall back to while the primary is unhealthy. Role data is only ever read
+// on the request path, so authorization keeps working through a primary outage; admin writes
+// still require the primary. A background probe switches back once the primary recovers.
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// primaryProbeInterval is how often the primary is pinged while a read-only fallback exists.
+const primaryProbeInterval = 5 * time.Second
+
+var (
+	mongoReadOnlyClient *mongo.Client
+	mongoReadOnlyDB     *mongo.Database
+	// primaryUnhealthy routes role lookups to the read-only connection while set.
+	primaryUnhealthy atomic.Bool
+)
+
+/*
+initReadOnlyMongo connects MONGO_URI_READONLY to the same database as the primary and starts
+the primary health probe. Unlike the primary, an unreachable replica only logs a warning.
+*/
+func initReadOnlyMongo(dbName string) {
+	uri := os.Getenv("MONGO_URI_READONLY")
+	if uri == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), envDuration("MONGO_CONNECT_TIMEOUT", 10*time.Second))
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		log.Fatal("Mongo read-only Connect error:", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		logWarn("read-only MongoDB not reachable at startup", "error", err)
+	}
+	mongoReadOnlyClient = client
+	mongoReadOnlyDB = client.Database(dbName)
+	logInfo("read-only MongoDB fallback configured for role lookups")
+
+	go func() {
+		for range time.Tick(primaryProbeInterval) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			err := mongoClient.Ping(ctx, nil)
+			cancel()
+			setPrimaryHealth(err)
+		}
+	}()
+}
+
+/*
+setPrimaryHealth records the outcome of a primary operation, logging transitions.
+*/
+func setPrimaryHealth(err error) {
+	if err != nil && err != mongo.ErrNoDocuments {
+		if !primaryUnhealthy.Swap(true) {
+			logWarn("primary MongoDB unhealthy, using read-only connection for role lookups", "error", err)
+		}
+		return
+	}
+	if primaryUnhealthy.Swap(false) {
+		logInfo("primary MongoDB healthy again, role lookups switched back")
+	}
+}
+
+/*
+activeMongoConnection names the connection role lookups currently use.
+*/
+func activeMongoConnection() string {
+	if mongoReadOnlyDB != nil && primaryUnhealthy.Load() {
+		return "readonly"
+	}
+	return "primary"
+}
+
+/*
+findRoleDocument reads a document for the request path from the named collection, using the
+read-only connection while the primary is unhealthy and retrying on it when the primary
+fails. ErrNoDocuments is an answer, not a failure, and is never retried.
+*/
+func findRoleDocument(ctx context.Context, collection string, filter interface{}, out interface{}) error {
+	if mongoReadOnlyDB == nil {
+		return mongoDB.Collection(collection).FindOne(ctx, filter).Decode(out)
+	}
+	if !primaryUnhealthy.Load() {
+		err := mongoDB.Collection(collection).FindOne(ctx, filter).Decode(out)
+		if err == nil || err == mongo.ErrNoDocuments || ctx.Err() != nil {
+			return err
+		}
+		setPrimaryHealth(err)
+	}
+	return mongoReadOnlyDB.Collection(collection).FindOne(ctx, filter).Decode(out)
+}
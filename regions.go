@@ -0,0 +1,371 @@
+// regions.go
+//
+// Region definitions used to expand region-based grants into countries. A built-in base
+// mapping is layered with organization-specific overrides from a JSON file and the
+// MongoDB "regions" collection; overrides replace the base definition per region key.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// RegionDefinition is a region override document, stored in the "regions" collection
+// or as an entry of REGIONS_FILE. Regions lists other region names whose countries are
+// included, so composite regions such as APAC need not repeat every country.
+type RegionDefinition struct {
+	Region    string   `bson:"region" json:"region"`
+	Countries []string `bson:"countries" json:"countries"`
+	Regions   []string `bson:"regions" json:"regions,omitempty"`
+}
+
+var (
+	regionsMu sync.RWMutex
+	// effectiveRegions is the merged region mapping. It is replaced wholesale on reload and
+	// never mutated in place, so readers may keep using a map after releasing the lock.
+	effectiveRegions = builtinRegions()
+	// regionReferences records, for each composite region, the regions it was built from.
+	regionReferences = map[string][]string{}
+	// regionsLoadedAt is when initRegions installed the merged mapping; zero until then.
+	regionsLoadedAt time.Time
+	// countryIndex is the reverse of effectiveRegions: each known ISO-2 code mapped to the
+	// sorted regions containing it. It is replaced together with effectiveRegions.
+	countryIndex = buildCountryIndex(effectiveRegions)
+)
+
+/*
+builtinRegions returns the static base mapping of region codes (e.g., "ASIA")
+to their corresponding lists of ISO-2 country codes.
+*/
+func builtinRegions() map[string][]string {
+	return map[string][]string{
+		// Africa (all African countries)
+		"AFRICA": {
+			"DZ", "AO", "BJ", "BW", "BF", "BI", "CV", "CM", "CF", "TD", "KM", "CG", "CD", "CI",
+			"DJ", "EG", "GQ", "ER", "SZ", "ET", "GA", "GM", "GH", "GN", "GW", "KE", "LS", "LR",
+			"LY", "MG", "MW", "ML", "MR", "MU", "MA", "MZ", "NA", "NE", "NG", "RW", "ST", "SN",
+			"SC", "SL", "SO", "ZA", "SS", "SD", "TZ", "TG", "TN", "UG", "EH", "ZM", "ZW",
+		},
+		// Asia (all Asian countries, including Middle East)
+		"ASIA": {
+			"AF", "AM", "AZ", "BH", "BD", "BT", "BN", "KH", "CN", "CY", "GE", "IN", "ID", "IR",
+			"IQ", "IL", "JP", "JO", "KZ", "KW", "KG", "LA", "LB", "MY", "MV", "MN", "MM", "NP",
+			"KP", "OM", "PK", "PS", "PH", "QA", "RU", "SA", "SG", "KR", "LK", "SY", "TW", "TJ",
+			"TH", "TL", "TR", "TM", "AE", "UZ", "VN", "YE",
+		},
+		// Europe
+		"EUROPE": {
+			"AL", "AD", "AT", "BY", "BE", "BA", "BG", "HR", "CY", "CZ", "DK", "EE", "FI", "FR",
+			"DE", "GR", "HU", "IS", "IE", "IT", "LV", "LI", "LT", "LU", "MT", "MD", "MC", "ME",
+			"NL", "MK", "NO", "PL", "PT", "RO", "SM", "RS", "SK", "SI", "ES", "SE", "CH", "UA", "GB", "VA",
+		},
+		// North America
+		"NORTH_AMERICA": {
+			"AG", "BS", "BB", "BZ", "CA", "CR", "CU", "DM", "DO", "SV", "GD", "GT", "HT", "HN",
+			"JM", "MX", "NI", "PA", "KN", "LC", "VC", "TT", "US",
+		},
+		// South America
+		"SOUTH_AMERICA": {
+			"AR", "BO", "BR", "CL", "CO", "EC", "GY", "PY", "PE", "SR", "UY", "VE",
+		},
+		// Oceania
+		"OCEANIA": {
+			"AU", "FJ", "KI", "MH", "FM", "NR", "NZ", "PW", "PG", "WS", "SB", "TO", "TV", "VU",
+		},
+		// Antarctica
+		"ANTARCTICA": {"AQ"},
+		// Global wildcard for all countries
+		"GLOBAL": {"*"},
+	}
+}
+
+/*
+regionMap returns the effective mapping of region codes to ISO-2 country codes,
+i.e. the built-in base merged with any loaded overrides. Callers must not modify it.
+*/
+func regionMap() map[string][]string {
+	regionsMu.RLock()
+	defer regionsMu.RUnlock()
+	return effectiveRegions
+}
+
+/*
+buildCountryIndex inverts a region mapping into country -> sorted region names. Wildcard
+regions such as GLOBAL are listed for every country.
+*/
+func buildCountryIndex(regions map[string][]string) map[string][]string {
+	index := make(map[string][]string)
+	var wildcards []string
+	for region, countries := range regions {
+		for _, c := range countries {
+			if c == "*" {
+				wildcards = append(wildcards, region)
+				continue
+			}
+			index[strings.ToUpper(c)] = append(index[strings.ToUpper(c)], region)
+		}
+	}
+	for c, names := range index {
+		names = append(names, wildcards...)
+		sort.Strings(names)
+		index[c] = names
+	}
+	return index
+}
+
+// countryAliases maps country codes that are not ISO 3166-1 alpha-2 but are common in
+// tokens, geolocation data, and older role documents to their ISO-2 code: "UK" for the
+// United Kingdom (GB) and "EL", the EU's code for Greece (GR).
+var countryAliases = map[string]string{
+	"UK": "GB",
+	"EL": "GR",
+}
+
+/*
+normalizeCountry uppercases a country code and replaces a known alias with its ISO-2 code,
+so "uk" and "GB" name the same country everywhere a code is compared.
+*/
+func normalizeCountry(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if iso, ok := countryAliases[code]; ok {
+		return iso
+	}
+	return code
+}
+
+/*
+regionsForCountry returns the sorted regions containing the ISO-2 code, and whether the code
+is known at all. Callers must not modify the result.
+*/
+func regionsForCountry(code string) ([]string, bool) {
+	regionsMu.RLock()
+	defer regionsMu.RUnlock()
+	regions, ok := countryIndex[normalizeCountry(code)]
+	return regions, ok
+}
+
+/*
+baseDefinitions converts the built-in region mapping into definitions with no references.
+*/
+func baseDefinitions() map[string]RegionDefinition {
+	defs := make(map[string]RegionDefinition)
+	for k, v := range builtinRegions() {
+		defs[k] = RegionDefinition{Region: k, Countries: v}
+	}
+	return defs
+}
+
+/*
+mergeRegions overlays region definitions onto a copy of base. Region keys, references, and
+country codes are normalized (see normalizeCountry); an override replaces the base definition entirely.
+*/
+func mergeRegions(base map[string]RegionDefinition, overrides []RegionDefinition) map[string]RegionDefinition {
+	merged := make(map[string]RegionDefinition, len(base)+len(overrides))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for _, def := range overrides {
+		key := strings.ToUpper(strings.TrimSpace(def.Region))
+		if key == "" {
+			continue
+		}
+		normalized := RegionDefinition{Region: key}
+		for _, c := range def.Countries {
+			normalized.Countries = append(normalized.Countries, normalizeCountry(c))
+		}
+		for _, r := range def.Regions {
+			normalized.Regions = append(normalized.Regions, strings.ToUpper(strings.TrimSpace(r)))
+		}
+		merged[key] = normalized
+	}
+	return merged
+}
+
+/*
+resolveRegions flattens every definition into its concrete country set by transitively
+expanding region references. It fails on references to unknown regions and on cycles,
+reporting the cycle path (e.g. "APAC -> ASIA_ALL -> APAC").
+*/
+func resolveRegions(defs map[string]RegionDefinition) (map[string][]string, error) {
+	resolved := make(map[string][]string, len(defs))
+	visiting := make(map[string]bool)
+
+	var visit func(key string, stack []string) error
+	visit = func(key string, stack []string) error {
+		if _, done := resolved[key]; done {
+			return nil
+		}
+		if visiting[key] {
+			return fmt.Errorf("region cycle: %s", strings.Join(append(stack, key), " -> "))
+		}
+		def := defs[key]
+		visiting[key] = true
+		set := make(map[string]struct{})
+		for _, c := range def.Countries {
+			set[c] = struct{}{}
+		}
+		for _, ref := range def.Regions {
+			if _, ok := defs[ref]; !ok {
+				return fmt.Errorf("region %s references unknown region %s", key, ref)
+			}
+			if err := visit(ref, append(stack, key)); err != nil {
+				return err
+			}
+			for _, c := range resolved[ref] {
+				set[c] = struct{}{}
+			}
+		}
+		visiting[key] = false
+
+		countries := make([]string, 0, len(set))
+		for c := range set {
+			countries = append(countries, c)
+		}
+		sort.Strings(countries)
+		resolved[key] = countries
+		return nil
+	}
+
+	for key := range defs {
+		if err := visit(key, nil); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+/*
+loadRegionOverridesFile reads REGIONS_FILE, a JSON array of RegionDefinition objects.
+*/
+func loadRegionOverridesFile(path string) ([]RegionDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var defs []RegionDefinition
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return nil, err
+	}
+	return defs, nil
+}
+
+/*
+loadRegionOverridesMongo reads every document of the "regions" collection.
+*/
+func loadRegionOverridesMongo(ctx context.Context) ([]RegionDefinition, error) {
+	cursor, err := mongoDB.Collection("regions").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var defs []RegionDefinition
+	if err := cursor.All(ctx, &defs); err != nil {
+		return nil, err
+	}
+	return defs, nil
+}
+
+/*
+initRegions builds the effective region mapping: built-in base, then REGIONS_FILE overrides,
+then MongoDB overrides, with later layers winning per region key. Region references are
+then resolved into flat country lists, so isCountryPermitted only ever sees countries.
+*/
+func initRegions() {
+	merged := baseDefinitions()
+
+	if path := os.Getenv("REGIONS_FILE"); path != "" {
+		defs, err := loadRegionOverridesFile(path)
+		if err != nil {
+			log.Fatalf("Failed to load REGIONS_FILE %s: %v", path, err)
+		}
+		merged = mergeRegions(merged, defs)
+		logInfo("loaded region overrides", "count", len(defs), "source", path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defs, err := loadRegionOverridesMongo(ctx)
+	if err != nil {
+		log.Fatal("Failed to load region overrides from MongoDB:", err)
+	}
+	merged = mergeRegions(merged, defs)
+	if len(defs) > 0 {
+		logInfo("loaded region overrides", "count", len(defs), "source", "mongo")
+	}
+
+	resolved, err := resolveRegions(merged)
+	if err != nil {
+		log.Fatal("Invalid region definitions: ", err)
+	}
+
+	references := make(map[string][]string)
+	for key, def := range merged {
+		if len(def.Regions) > 0 {
+			references[key] = def.Regions
+		}
+	}
+
+	index := buildCountryIndex(resolved)
+
+	regionsMu.Lock()
+	effectiveRegions = resolved
+	countryIndex = index
+	regionReferences = references
+	regionsLoadedAt = time.Now()
+	regionsMu.Unlock()
+	// Cached roles and decisions were computed from the previous mapping.
+	roleCaches.clear()
+	logInfo("effective region mapping loaded", "regions", len(resolved))
+}
+
+/*
+compositeRegions returns the region references of every composite region.
+Callers must not modify the result.
+*/
+func compositeRegions() map[string][]string {
+	regionsMu.RLock()
+	defer regionsMu.RUnlock()
+	return regionReferences
+}
+
+/*
+regionsHandler dumps the effective merged region mapping for debugging, with region keys
+and country lists sorted for readability.
+*/
+func regionsHandler(c *fiber.Ctx) error {
+	regions := regionMap()
+	out := make(map[string][]string, len(regions))
+	for k, v := range regions {
+		countries := append([]string(nil), v...)
+		sort.Strings(countries)
+		out[k] = countries
+	}
+	return c.JSON(out)
+}
+
+/*
+countryRegionsHandler answers GET /rbac/country/:code/regions with every region, including
+composite and wildcard regions, that contains the country. A malformed code is a 400 and a
+code absent from the region data a 404.
+*/
+func countryRegionsHandler(c *fiber.Ctx) error {
+	code := normalizeCountry(c.Params("code"))
+	if len(code) != 2 || strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("%q is not an ISO-2 country code", code)})
+	}
+	regions, ok := regionsForCountry(code)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": fmt.Sprintf("country %s is not in any region", code)})
+	}
+	return c.JSON(CountryRegionsResponse{Country: code, Regions: regions})
+}
@@ -0,0 +1,101 @@
+// regions_test.go
+//
+// Tests for region expansion and country code normalization.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveRegionsRejectsCycles(t *testing.T) {
+	defs := map[string]RegionDefinition{
+		"APAC":     {Region: "APAC", Countries: []string{"AU"}, Regions: []string{"ASIA_ALL"}},
+		"ASIA_ALL": {Region: "ASIA_ALL", Countries: []string{"TH"}, Regions: []string{"APAC"}},
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := resolveRegions(defs)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "region cycle: ") {
+			t.Fatalf("resolveRegions error = %v, want a region cycle", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("resolveRegions did not terminate on a cyclic region graph")
+	}
+}
+
+func TestResolveRegionsRejectsSelfReference(t *testing.T) {
+	defs := map[string]RegionDefinition{"LOOP": {Region: "LOOP", Regions: []string{"LOOP"}}}
+	if _, err := resolveRegions(defs); err == nil || !strings.Contains(err.Error(), "LOOP -> LOOP") {
+		t.Fatalf("resolveRegions error = %v, want cycle LOOP -> LOOP", err)
+	}
+}
+
+func TestResolveRegionsExpandsNestedRegions(t *testing.T) {
+	defs := map[string]RegionDefinition{
+		"APAC":      {Region: "APAC", Countries: []string{"AU"}, Regions: []string{"SEA"}},
+		"SEA":       {Region: "SEA", Countries: []string{"TH", "VN"}},
+		"UNRELATED": {Region: "UNRELATED", Countries: []string{"US"}},
+	}
+	resolved, err := resolveRegions(defs)
+	if err != nil {
+		t.Fatalf("resolveRegions: %v", err)
+	}
+	if got := strings.Join(resolved["APAC"], ","); got != "AU,TH,VN" {
+		t.Fatalf("APAC = %s, want AU,TH,VN", got)
+	}
+}
+
+func TestNormalizeCountry(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"UK", "GB"},
+		{"uk", "GB"},
+		{" gb ", "GB"},
+		{"EL", "GR"},
+		{"el", "GR"},
+		{"GR", "GR"},
+		{"th", "TH"},
+		{"GLOBAL", "GLOBAL"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeCountry(tt.in); got != tt.want {
+			t.Errorf("normalizeCountry(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	for alias, code := range countryAliases {
+		if !isKnownCountry(code) {
+			t.Errorf("alias %s maps to unknown country %s", alias, code)
+		}
+	}
+}
+
+func TestCountryAliasesInGrants(t *testing.T) {
+	tests := []struct {
+		name    string
+		perm    Permission
+		country string
+		want    bool
+	}{
+		{"GB under EUROPE admits a UK requirement", Permission{Path: "hr:**", Regions: []string{"EUROPE"}}, "UK", true},
+		{"GB under EUROPE admits a GB requirement", Permission{Path: "hr:**", Regions: []string{"EUROPE"}}, "GB", true},
+		{"listed UK admits a GB requirement", Permission{Path: "hr:**", Countries: []string{"UK"}}, "GB", true},
+		{"listed GB admits a UK requirement", Permission{Path: "hr:**", Countries: []string{"GB"}}, "UK", true},
+		{"listed EL admits a GR requirement", Permission{Path: "hr:**", Countries: []string{"EL"}}, "GR", true},
+		{"GR under EUROPE admits an EL requirement", Permission{Path: "hr:**", Regions: []string{"EUROPE"}}, "EL", true},
+		{"excluded UK denies GB", Permission{Path: "hr:**", Regions: []string{"EUROPE"}, ExceptCountries: []string{"UK"}}, "GB", false},
+		{"excluded GB denies UK", Permission{Path: "hr:**", Regions: []string{"EUROPE"}, ExceptCountries: []string{"GB"}}, "UK", false},
+	}
+	for _, tt := range tests {
+		user := userFromRoles("alice", []Role{{RoleID: "eu", Permissions: []Permission{tt.perm}}})
+		if got := IsAllowed(user, Requirement{Path: "hr:profile:view", Country: tt.country}); got != tt.want {
+			t.Errorf("%s: IsAllowed = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// responses.go
+//
+// Response types for the RBAC and admin endpoints, so their shape is stable and documented
+// in /openapi.json instead of built from ad-hoc maps. For the caller-introspection endpoints
+// (/user, /user/profile, and /rbac/capabilities) field names are snake_case by default;
+// RESPONSE_FIELD_CASE=camel renders them in camelCase for consumers that expect it.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// UserSummaryResponse is the body of GET /user: who the caller is and where they may act.
+type UserSummaryResponse struct {
+	Username         string   `json:"username"`
+	AllowedCountries []string `json:"allowed_countries"`
+}
+
+// ProfileResponse is the body of GET /user/profile. GrantedByRole names the role whose
+// permission allowed the request.
+type ProfileResponse struct {
+	User             string   `json:"user"`
+	Roles            []Role   `json:"roles"`
+	AllowedCountries []string `json:"allowed_countries"`
+	GrantedByRole    string   `json:"granted_by_role"`
+}
+
+// CapabilitiesResponse is the body of GET /rbac/capabilities. EffectivePermissions is only
+// set for ?merged=true.
+type CapabilitiesResponse struct {
+	User                 string       `json:"user"`
+	AllowedCountries     []string     `json:"allowed_countries"`
+	Capabilities         []Capability `json:"capabilities"`
+	EffectivePermissions []Permission `json:"effective_permissions,omitempty"`
+}
+
+// ErrorResponse is the body of every JSON error. Code is a stable value clients can act on
+// (see errorCodes). Mode, Satisfied, and Missing are only set for REQUIREMENTS_NOT_MET.
+type ErrorResponse struct {
+	Error     string              `json:"error"`
+	Code      string              `json:"code,omitempty"`
+	Detail    string              `json:"detail,omitempty"`
+	Mode      compositeMode       `json:"mode,omitempty"`
+	Satisfied []requirementResult `json:"satisfied,omitempty"`
+	Missing   []requirementResult `json:"missing,omitempty"`
+}
+
+// errorCodes are the codes an ErrorResponse may carry.
+var errorCodes = []string{
+	"PERMISSION_DENIED", roleRequiredCode, "STEP_UP_REQUIRED", "REAUTH_REQUIRED", "REQUIREMENTS_NOT_MET",
+	tooManyRolesCode, featureDisabledCode, legallyRestrictedCode, internalErrorCode,
+}
+
+// VersionResponse is the body of GET /version.
+type VersionResponse struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// CountryRegionsResponse is the body of GET /rbac/country/:code/regions.
+type CountryRegionsResponse struct {
+	Country string   `json:"country"`
+	Regions []string `json:"regions"`
+}
+
+// ExclusionListResponse is the body of GET /admin/exclusions. ReloadedAt is RFC 3339, or
+// null before the first load.
+type ExclusionListResponse struct {
+	Exclusions []PathExclusion `json:"exclusions"`
+	ReloadedAt interface{}     `json:"reloaded_at"`
+}
+
+// SimulateResponse is the body of POST /admin/roles/:id/simulate. Change is "granted",
+// "revoked", or "unchanged".
+type SimulateResponse struct {
+	RoleID   string `json:"role_id"`
+	Path     string `json:"path"`
+	Country  string `json:"country"`
+	Current  bool   `json:"current"`
+	Proposed bool   `json:"proposed"`
+	Change   string `json:"change"`
+}
+
+// AccessDiffResponse is the body of POST /admin/access/diff.
+type AccessDiffResponse struct {
+	A           string             `json:"a"`
+	B           string             `json:"b"`
+	Evaluated   int                `json:"evaluated"`
+	Differences []AccessDifference `json:"differences"`
+}
+
+// camelCaseResponses renders response field names in camelCase (RESPONSE_FIELD_CASE=camel).
+var camelCaseResponses bool
+
+// snakeField matches a snake_case field name such as "allowed_countries". Values used as
+// keys elsewhere, like region names ("SOUTHEAST_ASIA") or paths ("hr:payroll:view"), never
+// match, so only field names are renamed.
+var snakeField = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)+$`)
+
+/*
+initResponseCasing reads RESPONSE_FIELD_CASE (snake, the default, or camel).
+*/
+func initResponseCasing() {
+	switch v := os.Getenv("RESPONSE_FIELD_CASE"); v {
+	case "", "snake":
+	case "camel":
+		camelCaseResponses = true
+		logInfo("response field names are camelCase")
+	default:
+		log.Fatalf("Invalid RESPONSE_FIELD_CASE %q: expected snake or camel", v)
+	}
+}
+
+/*
+encodeResponse marshals a response type, renaming its fields to camelCase when configured.
+*/
+func encodeResponse(v interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil || !camelCaseResponses {
+		return body, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	// Numbers stay json.Number so re-encoding never changes their representation.
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return json.Marshal(camelKeys(generic))
+}
+
+/*
+camelKeys renames every snake_case object key in a decoded JSON value to camelCase.
+*/
+func camelKeys(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			if snakeField.MatchString(k) {
+				k = snakeToCamel(k)
+			}
+			out[k] = camelKeys(val)
+		}
+		return out
+	case []interface{}:
+		for i, val := range t {
+			t[i] = camelKeys(val)
+		}
+	}
+	return v
+}
+
+/*
+snakeToCamel converts "allowed_countries" to "allowedCountries".
+*/
+func snakeToCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+	}
+	return strings.Join(parts, "")
+}
+
+/*
+sendResponse writes a response type as JSON with the configured field casing.
+*/
+func sendResponse(c *fiber.Ctx, v interface{}) error {
+	body, err := encodeResponse(v)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(body)
+}
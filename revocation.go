@@ -0,0 +1,180 @@
+// revocation.go
+//
+// Optional denylist of revoked token IDs (jti) for incident response. Revocations are
+// stored in the MongoDB "revoked_tokens" collection with a TTL index on their expiry,
+// and mirrored in memory (refreshed periodically) so the per-request check is a map lookup.
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RevokedToken is a denylist entry. ExpiresAt is normally the revoked token's exp; once
+// it passes the token is rejected as expired anyway, so MongoDB deletes the entry.
+type RevokedToken struct {
+	JTI       string    `bson:"jti" json:"jti"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+}
+
+// jtiDenylist is the in-memory view of revoked token IDs and their expiry.
+type jtiDenylist struct {
+	mu         sync.RWMutex
+	entries    map[string]time.Time
+	reloadedAt time.Time
+}
+
+// revokedTokens is the active denylist. It is nil when JTI_DENYLIST_ENABLED is not set.
+var revokedTokens *jtiDenylist
+
+/*
+initJTIDenylist enables the jti denylist when JTI_DENYLIST_ENABLED=true, ensures the TTL
+index exists, and starts refreshing the in-memory copy every JTI_DENYLIST_REFRESH (30s).
+*/
+func initJTIDenylist() {
+	enabled, _ := strconv.ParseBool(os.Getenv("JTI_DENYLIST_ENABLED"))
+	if !enabled {
+		return
+	}
+	refresh := 30 * time.Second
+	if v := os.Getenv("JTI_DENYLIST_REFRESH"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid JTI_DENYLIST_REFRESH %q: must be a positive duration such as 30s", v)
+		}
+		refresh = d
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := mongoDB.Collection("revoked_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "jti", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		log.Fatal("Failed to create revoked_tokens indexes:", err)
+	}
+
+	d := &jtiDenylist{entries: make(map[string]time.Time)}
+	if err := d.reload(); err != nil {
+		log.Fatal("Failed to load revoked tokens:", err)
+	}
+	revokedTokens = d
+	go func() {
+		for range time.Tick(refresh) {
+			if err := d.reload(); err != nil {
+				logWarn("revoked token refresh failed", "error", err)
+			}
+		}
+	}()
+	logInfo("JTI denylist enabled", "entries", len(d.entries), "refresh", refresh)
+}
+
+/*
+reload replaces the in-memory denylist with the unexpired entries stored in MongoDB.
+*/
+func (d *jtiDenylist) reload() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cursor, err := mongoDB.Collection("revoked_tokens").Find(ctx, bson.M{"expires_at": bson.M{"$gt": time.Now()}})
+	if err != nil {
+		return err
+	}
+	var docs []RevokedToken
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+	entries := make(map[string]time.Time, len(docs))
+	for _, doc := range docs {
+		entries[doc.JTI] = doc.ExpiresAt
+	}
+	d.mu.Lock()
+	d.entries = entries
+	d.reloadedAt = time.Now()
+	d.mu.Unlock()
+	return nil
+}
+
+/*
+isRevoked reports whether jti is on the denylist and has not yet expired.
+*/
+func (d *jtiDenylist) isRevoked(jti string) bool {
+	d.mu.RLock()
+	expiresAt, ok := d.entries[jti]
+	d.mu.RUnlock()
+	return ok && time.Now().Before(expiresAt)
+}
+
+/*
+add stores a revocation in MongoDB and applies it locally right away, without waiting
+for the next refresh.
+*/
+func (d *jtiDenylist) add(ctx context.Context, entry RevokedToken) error {
+	_, err := mongoDB.Collection("revoked_tokens").UpdateOne(ctx,
+		bson.M{"jti": entry.JTI},
+		bson.M{"$set": bson.M{"expires_at": entry.ExpiresAt}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.entries[entry.JTI] = entry.ExpiresAt
+	d.mu.Unlock()
+	return nil
+}
+
+// revokeRequest is the body of POST /admin/tokens/revoke. Exp is the token's exp claim
+// (Unix seconds); when omitted the revocation is kept for 24 hours.
+type revokeRequest struct {
+	JTI string `json:"jti"`
+	Exp int64  `json:"exp"`
+}
+
+/*
+revokeTokenHandler adds a jti to the denylist.
+*/
+func revokeTokenHandler(c *fiber.Ctx) error {
+	revoker, err := currentUser(c)
+	if err != nil {
+		return err
+	}
+	if revokedTokens == nil {
+		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{"error": "JTI denylist is not enabled"})
+	}
+	var body revokeRequest
+	if err := decodeStrictJSON(c.Body(), &body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "Invalid revocation body",
+			"detail": err.Error(),
+		})
+	}
+	if body.JTI == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "jti is required"})
+	}
+	expiresAt := time.Now().Add(24 * time.Hour)
+	if body.Exp > 0 {
+		expiresAt = time.Unix(body.Exp, 0)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	entry := RevokedToken{JTI: body.JTI, ExpiresAt: expiresAt}
+	if err := revokedTokens.add(ctx, entry); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":  "Database write error",
+			"detail": err.Error(),
+		})
+	}
+	logInfo("token revoked", "jti", body.JTI, "revoker", logSafe(revoker.ID), "until", expiresAt.Format(time.RFC3339))
+	return c.Status(fiber.StatusCreated).JSON(entry)
+}
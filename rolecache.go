@@ -0,0 +1,272 @@
+// rolecache.go
+//
+// In-memory TTL cache for role documents loaded from MongoDB. Roles change rarely,
+// so caching them avoids one database round-trip per role on every request. MAX_CACHED_ROLES
+// optionally bounds the cache, evicting the least recently used role when it is full.
+// Hit, miss, and eviction counters are exported via /metrics and optional periodic logs.
+
+package main
+
+import (
+	"container/list"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// roleCacheEntry is a cached role together with its expiry time and its position in the
+// recency list.
+type roleCacheEntry struct {
+	role      Role
+	expiresAt time.Time
+	elem      *list.Element
+}
+
+// roleCache is a concurrency-safe TTL cache of roles keyed by role_id. Unbounded caches take
+// a shared lock on reads so concurrent requests never serialize on cache hits; a bounded
+// cache must record recency on every hit and therefore takes the exclusive lock. Writes,
+// sweeps, and reloads always take the exclusive lock.
+type roleCache struct {
+	mu         sync.RWMutex
+	ttl        time.Duration
+	maxEntries int        // 0 means unbounded
+	recency    *list.List // role ids, most recently used at the front
+	entries    map[string]roleCacheEntry
+
+	hits         atomic.Uint64
+	misses       atomic.Uint64
+	evictions    atomic.Uint64
+	lruEvictions atomic.Uint64
+	lastRefresh  atomic.Int64 // unix nanoseconds of the last role stored from MongoDB
+}
+
+// rolesCache is the process-wide role cache. It is nil when caching is disabled.
+var rolesCache *roleCache
+
+/*
+newRoleCache creates an empty role cache whose entries live for the given TTL. A positive
+maxEntries bounds the cache with least-recently-used eviction.
+*/
+func newRoleCache(ttl time.Duration, maxEntries int) *roleCache {
+	return &roleCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		recency:    list.New(),
+		entries:    make(map[string]roleCacheEntry),
+	}
+}
+
+/*
+get returns the cached role for roleID if present and not expired, marking it as most
+recently used. Expired entries are removed on access and counted as evictions.
+*/
+func (rc *roleCache) get(roleID string) (Role, bool) {
+	if rc.maxEntries > 0 {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		entry, ok := rc.entries[roleID]
+		if !ok {
+			rc.misses.Add(1)
+			return Role{}, false
+		}
+		if time.Now().After(entry.expiresAt) {
+			rc.remove(roleID, entry)
+			rc.evictions.Add(1)
+			rc.misses.Add(1)
+			return Role{}, false
+		}
+		rc.recency.MoveToFront(entry.elem)
+		rc.hits.Add(1)
+		return entry.role, true
+	}
+
+	rc.mu.RLock()
+	entry, ok := rc.entries[roleID]
+	rc.mu.RUnlock()
+	if !ok {
+		rc.misses.Add(1)
+		return Role{}, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		rc.mu.Lock()
+		// Re-check under the write lock: another goroutine may have refreshed the entry.
+		if current, ok := rc.entries[roleID]; ok && time.Now().After(current.expiresAt) {
+			rc.remove(roleID, current)
+			rc.evictions.Add(1)
+		}
+		rc.mu.Unlock()
+		rc.misses.Add(1)
+		return Role{}, false
+	}
+	rc.hits.Add(1)
+	return entry.role, true
+}
+
+/*
+set stores a role in the cache, replacing any existing entry and resetting its TTL. When a
+bounded cache is full, the least recently used roles are evicted to make room.
+*/
+func (rc *roleCache) set(roleID string, role Role) {
+	rc.lastRefresh.Store(time.Now().UnixNano())
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	expiresAt := time.Now().Add(rc.ttl)
+	if entry, ok := rc.entries[roleID]; ok {
+		rc.recency.MoveToFront(entry.elem)
+		rc.entries[roleID] = roleCacheEntry{role: role, expiresAt: expiresAt, elem: entry.elem}
+		return
+	}
+	rc.entries[roleID] = roleCacheEntry{role: role, expiresAt: expiresAt, elem: rc.recency.PushFront(roleID)}
+	for rc.maxEntries > 0 && len(rc.entries) > rc.maxEntries {
+		oldest := rc.recency.Back()
+		id := oldest.Value.(string)
+		rc.remove(id, rc.entries[id])
+		rc.lruEvictions.Add(1)
+	}
+}
+
+/*
+remove deletes an entry and its recency record. The caller must hold the exclusive lock.
+*/
+func (rc *roleCache) remove(roleID string, entry roleCacheEntry) {
+	rc.recency.Remove(entry.elem)
+	delete(rc.entries, roleID)
+}
+
+/*
+size returns the number of entries currently held, including not-yet-swept expired ones.
+*/
+func (rc *roleCache) size() int {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	return len(rc.entries)
+}
+
+/*
+invalidate drops a single role, e.g. after it was edited, so the next lookup reloads it.
+*/
+func (rc *roleCache) invalidate(roleID string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if entry, ok := rc.entries[roleID]; ok {
+		rc.remove(roleID, entry)
+	}
+}
+
+/*
+clear drops every cached role. It is safe to call while requests are reading the cache.
+*/
+func (rc *roleCache) clear() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.entries = make(map[string]roleCacheEntry)
+	rc.recency.Init()
+}
+
+/*
+snapshot returns a copy of every unexpired cached role, keyed by role_id.
+*/
+func (rc *roleCache) snapshot() map[string]Role {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	now := time.Now()
+	out := make(map[string]Role, len(rc.entries))
+	for id, entry := range rc.entries {
+		if now.Before(entry.expiresAt) {
+			out[id] = entry.role
+		}
+	}
+	return out
+}
+
+/*
+sweep removes every expired entry and counts each removal as an eviction.
+*/
+func (rc *roleCache) sweep() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	now := time.Now()
+	for id, entry := range rc.entries {
+		if now.After(entry.expiresAt) {
+			rc.remove(id, entry)
+			rc.evictions.Add(1)
+		}
+	}
+}
+
+/*
+hitRatio returns hits / (hits + misses), or 0 before any lookup has happened.
+*/
+func (rc *roleCache) hitRatio() float64 {
+	hits, misses := rc.hits.Load(), rc.misses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+/*
+initRoleCache enables the role cache when ROLE_CACHE_TTL is set to a positive duration
+(e.g. "30s"), registers its metrics, and starts the background sweeper. MAX_CACHED_ROLES
+bounds the number of cached roles (0, the default, means unbounded). When
+ROLE_CACHE_STATS_INTERVAL is set, cache statistics are also logged periodically.
+*/
+func initRoleCache() {
+	ttlStr := os.Getenv("ROLE_CACHE_TTL")
+	if ttlStr == "" {
+		logInfo("role cache disabled (ROLE_CACHE_TTL not set)")
+		return
+	}
+	ttl, err := time.ParseDuration(ttlStr)
+	if err != nil || ttl <= 0 {
+		log.Fatalf("Invalid ROLE_CACHE_TTL %q: must be a positive duration such as 30s", ttlStr)
+	}
+
+	maxEntries := 0
+	if v := os.Getenv("MAX_CACHED_ROLES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid MAX_CACHED_ROLES %q: must be a non-negative integer", v)
+		}
+		maxEntries = n
+	}
+
+	rc := newRoleCache(ttl, maxEntries)
+	rolesCache = rc
+
+	registerCounter("rbac_role_cache_hits_total", "Role lookups served from the cache.", rc.hits.Load)
+	registerCounter("rbac_role_cache_misses_total", "Role lookups that had to query MongoDB.", rc.misses.Load)
+	registerCounter("rbac_role_cache_evictions_total", "Cached roles removed after expiring.", rc.evictions.Load)
+	registerCounter("rbac_role_cache_lru_evictions_total", "Cached roles evicted because MAX_CACHED_ROLES was reached.", rc.lruEvictions.Load)
+	registerGauge("rbac_role_cache_size", "Number of roles currently cached.", func() float64 {
+		return float64(rc.size())
+	})
+
+	go func() {
+		for range time.Tick(ttl) {
+			rc.sweep()
+		}
+	}()
+
+	if intervalStr := os.Getenv("ROLE_CACHE_STATS_INTERVAL"); intervalStr != "" {
+		interval, err := time.ParseDuration(intervalStr)
+		if err != nil || interval <= 0 {
+			log.Fatalf("Invalid ROLE_CACHE_STATS_INTERVAL %q: must be a positive duration such as 1m", intervalStr)
+		}
+		go func() {
+			for range time.Tick(interval) {
+				logInfo("role cache stats", "size", rc.size(), "hits", rc.hits.Load(), "misses", rc.misses.Load(),
+					"evictions", rc.evictions.Load(), "lru_evictions", rc.lruEvictions.Load(), "hit_ratio", rc.hitRatio())
+			}
+		}()
+	}
+
+	if maxEntries > 0 {
+		logInfo("role cache enabled", "ttl", ttl, "max_entries", maxEntries)
+		return
+	}
+	logInfo("role cache enabled", "ttl", ttl)
+}
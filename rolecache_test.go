@@ -0,0 +1,94 @@
+// rolecache_test.go
+//
+// Tests for the role cache. Run with -race: the concurrency test is only meaningful under
+// the race detector.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRoleCacheConcurrentAccess(t *testing.T) {
+	for _, maxEntries := range []int{0, 8} {
+		t.Run(fmt.Sprintf("max=%d", maxEntries), func(t *testing.T) {
+			rc := newRoleCache(time.Minute, maxEntries)
+			var wg sync.WaitGroup
+			for g := 0; g < 16; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := 0; i < 500; i++ {
+						id := fmt.Sprintf("role-%d", (g+i)%12)
+						switch i % 5 {
+						case 0:
+							rc.set(id, Role{RoleID: id})
+						case 1:
+							rc.invalidate(id)
+						case 2:
+							rc.snapshot()
+						default:
+							if role, ok := rc.get(id); ok && role.RoleID != id {
+								t.Errorf("get(%s) returned role %s", id, role.RoleID)
+							}
+						}
+					}
+				}(g)
+			}
+			// Reloads clear the whole cache while the readers are running.
+			for i := 0; i < 50; i++ {
+				rc.clear()
+				rc.sweep()
+			}
+			wg.Wait()
+			if maxEntries > 0 && rc.size() > maxEntries {
+				t.Fatalf("size = %d, want at most %d", rc.size(), maxEntries)
+			}
+		})
+	}
+}
+
+func TestRoleCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	rc := newRoleCache(time.Minute, 3)
+	for _, id := range []string{"a", "b", "c"} {
+		rc.set(id, Role{RoleID: id})
+	}
+	// Reading "a" makes "b" the least recently used entry.
+	if _, ok := rc.get("a"); !ok {
+		t.Fatal("get(a) missed before the cache was full")
+	}
+	rc.set("d", Role{RoleID: "d"})
+
+	if rc.size() != 3 {
+		t.Fatalf("size = %d, want 3", rc.size())
+	}
+	if _, ok := rc.get("b"); ok {
+		t.Error("least recently used role b was not evicted")
+	}
+	for _, id := range []string{"a", "c", "d"} {
+		if _, ok := rc.get(id); !ok {
+			t.Errorf("role %s was evicted", id)
+		}
+	}
+	if n := rc.lruEvictions.Load(); n != 1 {
+		t.Errorf("lruEvictions = %d, want 1", n)
+	}
+}
+
+func TestRoleCacheUpdateKeepsSize(t *testing.T) {
+	rc := newRoleCache(time.Minute, 2)
+	rc.set("a", Role{RoleID: "a"})
+	rc.set("b", Role{RoleID: "b"})
+	// Replacing an entry refreshes it instead of evicting anything.
+	rc.set("a", Role{RoleID: "a", Description: "edited"})
+	rc.set("c", Role{RoleID: "c"})
+	if role, ok := rc.get("a"); !ok || role.Description != "edited" {
+		t.Fatalf("get(a) = %+v, %v, want the edited role", role, ok)
+	}
+	if _, ok := rc.get("b"); ok {
+		t.Error("b should have been evicted as least recently used")
+	}
+}
@@ -0,0 +1,191 @@
+// routelimit.go
+//
+// Global per-route rate limits, a safety valve that keeps traffic spikes on one endpoint
+// from overwhelming MongoDB regardless of which users are calling. ROUTE_RATE_LIMITS maps
+// route patterns to a sustained rate and burst, e.g.
+//
+//	GET /user/payroll=50:100,/admin/*=10:20
+//
+// Patterns use the route table syntax (":name" segments, a trailing "*"; a missing method
+// means any method) and the most specific matching rule applies. Each rule is one token
+// bucket shared by all clients. ROUTE_RATE_LIMIT_EXEMPT paths are never limited.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultRateLimitExempt keeps probes and scrapers working during a spike.
+const defaultRateLimitExempt = "/healthz,/metrics"
+
+// tokenBucket allows rate requests per second on average with bursts of up to burst.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+/*
+newTokenBucket creates a full bucket.
+*/
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+/*
+take consumes one token if available. Otherwise it reports how long until one is.
+*/
+func (b *tokenBucket) take() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// routeLimit is one ROUTE_RATE_LIMITS rule.
+type routeLimit struct {
+	route  mappedRoute
+	bucket *tokenBucket
+}
+
+var (
+	// routeLimits are the configured rules ordered from most to least specific.
+	routeLimits []routeLimit
+	// rateLimitExempt are paths (exact, or prefixes ending in "*") never rate limited.
+	rateLimitExempt []string
+	// routeRateLimited counts requests rejected by a route limit.
+	routeRateLimited atomic.Uint64
+)
+
+/*
+parseRouteLimits parses ROUTE_RATE_LIMITS entries of the form "[METHOD ]/pattern=rps:burst".
+*/
+func parseRouteLimits(raw string) ([]routeLimit, error) {
+	var limits []routeLimit
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, spec, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: expected [METHOD ]/pattern=rps:burst", entry)
+		}
+		key = strings.TrimSpace(key)
+		if strings.HasPrefix(key, "/") {
+			key = "* " + key
+		}
+		method, pattern, _ := strings.Cut(key, " ")
+		pattern = strings.TrimSpace(pattern)
+		if !strings.HasPrefix(pattern, "/") {
+			return nil, fmt.Errorf("%q: pattern must start with /", entry)
+		}
+		rateStr, burstStr, _ := strings.Cut(strings.TrimSpace(spec), ":")
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("%q: rate must be a positive number", entry)
+		}
+		burst := math.Max(1, math.Ceil(rate))
+		if burstStr != "" {
+			n, err := strconv.Atoi(burstStr)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("%q: burst must be a positive integer", entry)
+			}
+			burst = float64(n)
+		}
+		limits = append(limits, routeLimit{
+			route:  mappedRoute{method: strings.ToUpper(method), pattern: pattern, segments: routeSegments(pattern)},
+			bucket: newTokenBucket(rate, burst),
+		})
+	}
+	sort.SliceStable(limits, func(i, j int) bool { return moreSpecific(limits[i].route, limits[j].route) })
+	return limits, nil
+}
+
+/*
+routeRateLimiter returns the global per-route limiter configured by ROUTE_RATE_LIMITS and
+ROUTE_RATE_LIMIT_EXEMPT, or nil when no limits are set.
+*/
+func routeRateLimiter() fiber.Handler {
+	raw := os.Getenv("ROUTE_RATE_LIMITS")
+	if raw == "" {
+		return nil
+	}
+	limits, err := parseRouteLimits(raw)
+	if err != nil {
+		log.Fatalf("Invalid ROUTE_RATE_LIMITS: %v", err)
+	}
+	routeLimits = limits
+	exempt := defaultRateLimitExempt
+	if v, ok := os.LookupEnv("ROUTE_RATE_LIMIT_EXEMPT"); ok {
+		exempt = v
+	}
+	rateLimitExempt = nil
+	for _, p := range strings.Split(exempt, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			rateLimitExempt = append(rateLimitExempt, p)
+		}
+	}
+	registerCounter("rbac_route_rate_limited_total", "Requests rejected by a global route rate limit.", routeRateLimited.Load)
+	logInfo("global route rate limits enabled", "patterns", len(limits))
+
+	return func(c *fiber.Ctx) error {
+		path := c.Path()
+		for _, p := range rateLimitExempt {
+			if path == p || (strings.HasSuffix(p, "*") && strings.HasPrefix(path, strings.TrimSuffix(p, "*"))) {
+				return c.Next()
+			}
+		}
+		limit, ok := matchRouteLimit(c.Method(), path)
+		if !ok {
+			return c.Next()
+		}
+		allowed, wait := limit.bucket.take()
+		if allowed {
+			return c.Next()
+		}
+		routeRateLimited.Add(1)
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": "Too many requests for this endpoint, retry later.",
+		})
+	}
+}
+
+/*
+matchRouteLimit returns the most specific rule matching the method and path. HEAD requests
+use GET rules.
+*/
+func matchRouteLimit(method, path string) (routeLimit, bool) {
+	segments := routeSegments(path)
+	for _, limit := range routeLimits {
+		m := limit.route.method
+		if m != "*" && m != method && !(method == fiber.MethodHead && m == fiber.MethodGet) {
+			continue
+		}
+		if matchRoute(limit.route.segments, segments) {
+			return limit, true
+		}
+	}
+	return routeLimit{}, false
+}
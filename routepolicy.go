@@ -0,0 +1,267 @@
+// routepolicy.go
+//
+// Route-to-permission mapping table used when the protected routes are not this service's
+// own, e.g. when acting as Envoy's external authorization server or as a PDP. Entries come
+// from ROUTE_PERMISSIONS_FILE, a JSON object keyed by "METHOD /path/pattern":
+//
+//	{"GET /user/payroll": {"path": "hr:payroll:view", "country": "TH"},
+//	 "* /admin/*":        {"path": "admin:**", "country": "GLOBAL"}}
+//
+// and from the MongoDB "route_permissions" collection, whose entries replace file entries
+// with the same key. Patterns support ":name" for one segment and a trailing "*" for any
+// remainder; method "*" matches every method. The most specific matching entry wins.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// routePolicy is the Requirement configured for one method and route pattern. Method and
+// Route are only set for MongoDB documents; file entries carry them in the object key. The
+// route manifest uses the same shape for its requirements.
+type routePolicy struct {
+	Method        string   `bson:"method" json:"-" yaml:"-"`
+	Route         string   `bson:"route" json:"-" yaml:"-"`
+	Path          string   `bson:"path" json:"path" yaml:"path"`
+	Country       string   `bson:"country" json:"country" yaml:"country"`
+	MinAcr        string   `bson:"min_acr,omitempty" json:"min_acr,omitempty" yaml:"min_acr,omitempty"`
+	RequiredAmr   []string `bson:"required_amr,omitempty" json:"required_amr,omitempty" yaml:"required_amr,omitempty"`
+	Audience      string   `bson:"audience,omitempty" json:"audience,omitempty" yaml:"audience,omitempty"`
+	MaxTokenAge   string   `bson:"max_token_age,omitempty" json:"max_token_age,omitempty" yaml:"max_token_age,omitempty"`
+	Feature       string   `bson:"feature,omitempty" json:"feature,omitempty" yaml:"feature,omitempty"`
+	DenyMessage   string   `bson:"deny_message,omitempty" json:"deny_message,omitempty" yaml:"deny_message,omitempty"`
+	RequiredRoles []string `bson:"required_roles,omitempty" json:"required_roles,omitempty" yaml:"required_roles,omitempty"`
+}
+
+// mappedRoute is a loaded table entry with its permission middleware built once.
+type mappedRoute struct {
+	method      string
+	pattern     string
+	segments    []string
+	requirement Requirement
+	guard       fiber.Handler
+}
+
+// routePolicies is the mapping table ordered from most to least specific.
+var routePolicies []mappedRoute
+
+/*
+initRoutePolicies loads the mapping table from ROUTE_PERMISSIONS_FILE and then MongoDB.
+Every entry is validated like a route Requirement at startup, so a bad table stops the
+service instead of denying at runtime. Route-parameter countries are rejected because
+the mapped pattern's parameters are not Fiber route parameters.
+*/
+func initRoutePolicies() {
+	table := make(map[string]routePolicy)
+	if path := os.Getenv("ROUTE_PERMISSIONS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read ROUTE_PERMISSIONS_FILE %s: %v", path, err)
+		}
+		var fileTable map[string]routePolicy
+		if err := json.Unmarshal(data, &fileTable); err != nil {
+			log.Fatalf("Invalid ROUTE_PERMISSIONS_FILE %s: %v", path, err)
+		}
+		for key, policy := range fileTable {
+			table[key] = policy
+		}
+		logInfo("loaded route permission mappings", "count", len(fileTable), "source", path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cursor, err := mongoDB.Collection("route_permissions").Find(ctx, bson.M{})
+	if err != nil {
+		log.Fatal("Failed to load route permissions from MongoDB:", err)
+	}
+	var docs []routePolicy
+	if err := cursor.All(ctx, &docs); err != nil {
+		log.Fatal("Failed to load route permissions from MongoDB:", err)
+	}
+	for _, doc := range docs {
+		table[doc.Method+" "+doc.Route] = doc
+	}
+	if len(docs) > 0 {
+		logInfo("loaded route permission mappings", "count", len(docs), "source", "mongo")
+	}
+
+	routes := make([]mappedRoute, 0, len(table))
+	for key, policy := range table {
+		route, err := parseRoutePolicy(key, policy)
+		if err != nil {
+			log.Fatalf("Invalid route permission %q: %v", key, err)
+		}
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool { return moreSpecific(routes[i], routes[j]) })
+	routePolicies = routes
+}
+
+/*
+requirement converts the policy to a validated Requirement. Countries are uppercased,
+except route-parameter countries, whose parameter name is kept as written.
+*/
+func (policy routePolicy) requirement() (Requirement, error) {
+	country := policy.Country
+	if !strings.HasPrefix(country, CountryParamPrefix) {
+		country = normalizeCountry(country)
+	}
+	req := Requirement{
+		Path:          policy.Path,
+		Country:       country,
+		MinAcr:        policy.MinAcr,
+		RequiredAmr:   policy.RequiredAmr,
+		Audience:      policy.Audience,
+		Feature:       policy.Feature,
+		DenyMessage:   policy.DenyMessage,
+		RequiredRoles: policy.RequiredRoles,
+	}
+	if policy.MaxTokenAge != "" {
+		maxAge, err := time.ParseDuration(policy.MaxTokenAge)
+		if err != nil || maxAge <= 0 {
+			return Requirement{}, fmt.Errorf("max_token_age %q must be a positive duration such as 5m", policy.MaxTokenAge)
+		}
+		req.MaxTokenAge = maxAge
+	}
+	if err := validateRequirement(req); err != nil {
+		return Requirement{}, err
+	}
+	return req, nil
+}
+
+/*
+parseRoutePolicy validates a "METHOD /pattern" key and its policy and builds the table
+entry, including its permission middleware.
+*/
+func parseRoutePolicy(key string, policy routePolicy) (mappedRoute, error) {
+	method, pattern, ok := strings.Cut(strings.TrimSpace(key), " ")
+	pattern = strings.TrimSpace(pattern)
+	if !ok || method == "" || !strings.HasPrefix(pattern, "/") {
+		return mappedRoute{}, fmt.Errorf(`key must look like "GET /path"`)
+	}
+	segments := routeSegments(pattern)
+	for i, segment := range segments {
+		if segment == "*" && i != len(segments)-1 {
+			return mappedRoute{}, fmt.Errorf("wildcard must be the last segment")
+		}
+		if segment == ":" {
+			return mappedRoute{}, fmt.Errorf("parameter segment has no name")
+		}
+	}
+	req, err := policy.requirement()
+	if err != nil {
+		return mappedRoute{}, err
+	}
+	if strings.HasPrefix(req.Country, CountryParamPrefix) {
+		return mappedRoute{}, fmt.Errorf("route parameter countries are not supported in the mapping table")
+	}
+	return mappedRoute{
+		method:      strings.ToUpper(method),
+		pattern:     pattern,
+		segments:    segments,
+		requirement: req,
+		guard:       requirePermission(req),
+	}, nil
+}
+
+/*
+routeSegments splits a URL path or pattern into its segments, ignoring leading and
+trailing slashes.
+*/
+func routeSegments(path string) []string {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return nil
+	}
+	return strings.Split(trimmed, "/")
+}
+
+/*
+moreSpecific orders table entries for lookup. At the first differing segment, a literal
+beats a ":param", which beats a trailing "*"; a longer literal prefix therefore wins.
+When one pattern only extends the other by a trailing "*", the shorter one wins, since
+"*" also matches an empty remainder. Then an exact method beats "*", and finally keys
+sort alphabetically for determinism.
+*/
+func moreSpecific(a, b mappedRoute) bool {
+	n := len(a.segments)
+	if len(b.segments) < n {
+		n = len(b.segments)
+	}
+	for i := 0; i < n; i++ {
+		if ra, rb := segmentRank(a.segments[i]), segmentRank(b.segments[i]); ra != rb {
+			return ra > rb
+		}
+	}
+	if len(a.segments) != len(b.segments) {
+		if len(a.segments) > n {
+			return a.segments[n] != "*"
+		}
+		return b.segments[n] == "*"
+	}
+	if (a.method == "*") != (b.method == "*") {
+		return b.method == "*"
+	}
+	return a.method+" "+a.pattern < b.method+" "+b.pattern
+}
+
+/*
+segmentRank scores a pattern segment by specificity: literal 2, parameter 1, wildcard 0.
+*/
+func segmentRank(segment string) int {
+	switch {
+	case segment == "*":
+		return 0
+	case strings.HasPrefix(segment, ":"):
+		return 1
+	}
+	return 2
+}
+
+/*
+matchRoute reports whether a request path's segments match a pattern's segments.
+*/
+func matchRoute(pattern, path []string) bool {
+	for i, segment := range pattern {
+		if segment == "*" {
+			return true
+		}
+		if i >= len(path) {
+			return false
+		}
+		if !strings.HasPrefix(segment, ":") && segment != path[i] {
+			return false
+		}
+	}
+	return len(pattern) == len(path)
+}
+
+/*
+lookupRoutePolicy returns the most specific table entry matching a method and request path.
+HEAD requests use GET entries, mirroring the router.
+*/
+func lookupRoutePolicy(method, path string) (mappedRoute, bool) {
+	method = strings.ToUpper(method)
+	segments := routeSegments(path)
+	for _, route := range routePolicies {
+		if route.method != "*" && route.method != method &&
+			!(method == fiber.MethodHead && route.method == fiber.MethodGet) {
+			continue
+		}
+		if matchRoute(route.segments, segments) {
+			return route, true
+		}
+	}
+	return mappedRoute{}, false
+}
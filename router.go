@@ -0,0 +1,194 @@
+// router.go
+//
+// Route registration helper that makes each route's security posture explicit. Every
+// route is declared public, authenticated, or protected by a Requirement; the router
+// wires the matching middleware exactly once, rejects conflicting or duplicate
+// registrations, and logs the resulting route table at startup.
+
+package main
+
+import (
+	"log"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// routeAccess is the security posture of a registered route.
+type routeAccess string
+
+const (
+	accessPublic        routeAccess = "public"
+	accessAuthenticated routeAccess = "authenticated"
+	accessProtected     routeAccess = "protected"
+	accessMapped        routeAccess = "mapped"
+	accessComposite     routeAccess = "composite"
+)
+
+// routeEntry is one row of the route table.
+type routeEntry struct {
+	method      string
+	path        string
+	access      routeAccess
+	requirement Requirement
+	// mode and requirements describe an accessComposite route.
+	mode         compositeMode
+	requirements []Requirement
+}
+
+// Router wraps a Fiber app and records how each route is secured.
+type Router struct {
+	app    *fiber.App
+	routes map[string]routeEntry
+}
+
+/*
+newRouter creates a Router registering routes on app.
+*/
+func newRouter(app *fiber.App) *Router {
+	return &Router{app: app, routes: make(map[string]routeEntry)}
+}
+
+/*
+register records a route, refusing to register the same method and path twice so that a
+route can never end up with two different security postures. GET routes are also served
+for HEAD with the same handlers, so HEAD is authorized by exactly the GET Requirement and
+the response body is dropped by the server.
+*/
+func (r *Router) register(entry routeEntry, handlers ...fiber.Handler) {
+	r.add(entry, handlers...)
+	if entry.method == fiber.MethodGet {
+		head := entry
+		head.method = fiber.MethodHead
+		r.add(head, handlers...)
+	}
+}
+
+/*
+add records and registers a single method and path.
+*/
+func (r *Router) add(entry routeEntry, handlers ...fiber.Handler) {
+	key := entry.method + " " + entry.path
+	if existing, dup := r.routes[key]; dup {
+		log.Fatalf("Route %s registered twice (already %s)", key, existing.access)
+	}
+	r.routes[key] = entry
+	r.app.Add(entry.method, entry.path, handlers...)
+}
+
+/*
+Public registers a route reachable without a token. The path must be covered by
+PUBLIC_PATHS, otherwise the global auth gate would reject it and the route would be
+silently broken.
+*/
+func (r *Router) Public(method, path string, handlers ...fiber.Handler) {
+	if !isPublicPath(path) {
+		log.Fatalf("Public route %s %s is not covered by PUBLIC_PATHS", method, path)
+	}
+	r.register(routeEntry{method: method, path: path, access: accessPublic}, handlers...)
+}
+
+/*
+Authenticated registers a route that needs a valid token and resolved user but no
+specific permission, such as introspection endpoints.
+*/
+func (r *Router) Authenticated(method, path string, handlers ...fiber.Handler) {
+	r.guardNotPublic(method, path)
+	r.register(routeEntry{method: method, path: path, access: accessAuthenticated},
+		guarded(requireAuthenticated(), handlers)...)
+}
+
+/*
+Protected registers a route guarded by requirePermission(req). The middleware is added
+here, so handlers must not include it themselves.
+*/
+func (r *Router) Protected(method, path string, req Requirement, handlers ...fiber.Handler) {
+	r.guardNotPublic(method, path)
+	r.register(routeEntry{method: method, path: path, access: accessProtected, requirement: req},
+		guarded(requirePermission(req), handlers)...)
+}
+
+/*
+ProtectedAll registers a route guarded by requireAll(reqs...): every Requirement must be met.
+*/
+func (r *Router) ProtectedAll(method, path string, reqs []Requirement, handlers ...fiber.Handler) {
+	r.guardNotPublic(method, path)
+	r.register(routeEntry{method: method, path: path, access: accessComposite, mode: compositeAll, requirements: reqs},
+		guarded(requireAll(reqs...), handlers)...)
+}
+
+/*
+ProtectedAny registers a route guarded by requireAny(reqs...): one met Requirement suffices.
+*/
+func (r *Router) ProtectedAny(method, path string, reqs []Requirement, handlers ...fiber.Handler) {
+	r.guardNotPublic(method, path)
+	r.register(routeEntry{method: method, path: path, access: accessComposite, mode: compositeAny, requirements: reqs},
+		guarded(requireAny(reqs...), handlers)...)
+}
+
+/*
+guarded prepends the guard to the handlers, followed by the internal token middleware when
+INTERNAL_TOKEN_SECRET is set.
+*/
+func guarded(guard fiber.Handler, handlers []fiber.Handler) []fiber.Handler {
+	chain := []fiber.Handler{guard}
+	if internalTokenKey != nil {
+		chain = append(chain, forwardInternalToken)
+	}
+	return append(chain, handlers...)
+}
+
+/*
+Mapped registers a route whose Requirement is looked up per request in the route-to-permission
+table. The handlers are responsible for running the mapped permission middleware.
+*/
+func (r *Router) Mapped(method, path string, handlers ...fiber.Handler) {
+	r.guardNotPublic(method, path)
+	r.register(routeEntry{method: method, path: path, access: accessMapped}, handlers...)
+}
+
+/*
+guardNotPublic refuses to secure a route whose path PUBLIC_PATHS exempts from
+authentication, since the two declarations contradict each other.
+*/
+func (r *Router) guardNotPublic(method, path string) {
+	if isPublicPath(path) {
+		log.Fatalf("Route %s %s requires authentication but is covered by PUBLIC_PATHS", method, path)
+	}
+}
+
+/*
+LogRoutes logs the route table sorted by path, one info line per route with its access and
+required permission.
+*/
+func (r *Router) LogRoutes() {
+	if !logEnabled(levelInfo) {
+		return
+	}
+	entries := make([]routeEntry, 0, len(r.routes))
+	for _, e := range r.routes {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].path != entries[j].path {
+			return entries[i].path < entries[j].path
+		}
+		return entries[i].method < entries[j].method
+	})
+	for _, e := range entries {
+		fields := []interface{}{"method", e.method, "path", e.path, "access", string(e.access)}
+		switch e.access {
+		case accessProtected:
+			fields = append(fields, "permission", e.requirement.Path, "country", e.requirement.Country)
+		case accessComposite:
+			parts := make([]string, len(e.requirements))
+			for i, req := range e.requirements {
+				parts[i] = req.Path + " @ " + req.Country
+			}
+			fields = append(fields, "mode", string(e.mode), "requirements", parts)
+		case accessMapped:
+			fields = append(fields, "mappings", len(routePolicies))
+		}
+		logInfo("route", fields...)
+	}
+}
@@ -0,0 +1,153 @@
+// router_test.go
+//
+// Tests for route registration and the security posture of registered routes.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/example/fiber-demo/testutil"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestHeadMirrorsGet(t *testing.T) {
+	useCachedRoles(t,
+		Role{RoleID: "viewer", Permissions: []Permission{{Path: "hr:profile:view", Countries: []string{"TH"}}}},
+		Role{RoleID: "other", Permissions: []Permission{{Path: "finance:**", Countries: []string{"TH"}}}},
+	)
+	app := fiber.New()
+	r := newRouter(app)
+	r.Protected(fiber.MethodGet, "/profile", Requirement{Path: "hr:profile:view", Country: "TH"},
+		func(c *fiber.Ctx) error { return c.SendString("profile") })
+
+	if entry, ok := r.routes["HEAD /profile"]; !ok || entry.requirement.Path != "hr:profile:view" {
+		t.Fatalf("HEAD /profile entry = %+v, %v, want the GET requirement", entry, ok)
+	}
+	for _, tt := range []struct {
+		role string
+		want int
+	}{
+		{"viewer", fiber.StatusOK},
+		{"other", fiber.StatusForbidden},
+	} {
+		token, err := testutil.UnsignedToken(testutil.TokenSpec{Username: "alice", Roles: []string{tt.role}})
+		if err != nil {
+			t.Fatalf("UnsignedToken: %v", err)
+		}
+		for _, method := range []string{fiber.MethodGet, fiber.MethodHead} {
+			req := httptest.NewRequest(method, "/profile", nil)
+			req.Header.Set("Authorization", testutil.BearerHeader(token))
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("%s /profile: %v", method, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.want {
+				t.Errorf("%s /profile as %s = %d, want %d", method, tt.role, resp.StatusCode, tt.want)
+			}
+			if method == fiber.MethodHead && len(body) != 0 {
+				t.Errorf("HEAD /profile returned a body: %q", body)
+			}
+		}
+	}
+}
+
+func TestLookupRoutePolicyHeadUsesGet(t *testing.T) {
+	previous := routePolicies
+	t.Cleanup(func() { routePolicies = previous })
+	routePolicies = []mappedRoute{{method: fiber.MethodGet, segments: routeSegments("/user/:id"),
+		requirement: Requirement{Path: "hr:profile:view", Country: "TH"}}}
+
+	route, ok := lookupRoutePolicy("head", "/user/42")
+	if !ok || route.requirement.Path != "hr:profile:view" {
+		t.Fatalf("lookupRoutePolicy(HEAD) = %+v, %v, want the GET entry", route, ok)
+	}
+	if _, ok := lookupRoutePolicy(fiber.MethodPost, "/user/42"); ok {
+		t.Fatal("lookupRoutePolicy(POST) matched a GET entry")
+	}
+}
+
+func TestLookupRoutePolicyExactBeatsTrailingWildcard(t *testing.T) {
+	previous := routePolicies
+	t.Cleanup(func() { routePolicies = previous })
+	table := map[string]string{
+		"GET /user":     "hr:profile:view",
+		"* /user/*":     "hr:user:any",
+		"GET /user/:id": "hr:user:view",
+		"GET /user/me":  "hr:user:self",
+		"GET /admin/*":  "admin:any",
+	}
+	routePolicies = nil
+	for key, path := range table {
+		route, err := parseRoutePolicy(key, routePolicy{Path: path, Country: "TH"})
+		if err != nil {
+			t.Fatalf("parseRoutePolicy(%q) = %v", key, err)
+		}
+		routePolicies = append(routePolicies, route)
+	}
+	sort.Slice(routePolicies, func(i, j int) bool { return moreSpecific(routePolicies[i], routePolicies[j]) })
+
+	tests := []struct {
+		method, path, want string
+	}{
+		{fiber.MethodGet, "/user", "hr:profile:view"},
+		{fiber.MethodGet, "/user/", "hr:profile:view"},
+		{fiber.MethodPost, "/user", "hr:user:any"},
+		{fiber.MethodGet, "/user/42", "hr:user:view"},
+		{fiber.MethodGet, "/user/me", "hr:user:self"},
+		{fiber.MethodGet, "/user/42/roles", "hr:user:any"},
+		{fiber.MethodGet, "/admin", "admin:any"},
+	}
+	for _, tt := range tests {
+		route, ok := lookupRoutePolicy(tt.method, tt.path)
+		if !ok || route.requirement.Path != tt.want {
+			t.Errorf("lookupRoutePolicy(%s %s) = %q, %v, want %q", tt.method, tt.path, route.requirement.Path, ok, tt.want)
+		}
+	}
+}
+
+func TestProfileReportsGrantingRole(t *testing.T) {
+	useCachedRoles(t,
+		Role{RoleID: "hr_all", Permissions: []Permission{{Path: "hr:**", Regions: []string{"GLOBAL"}}}},
+		Role{RoleID: "hr_profile", Permissions: []Permission{{Path: "hr:profile:view", Regions: []string{"GLOBAL"}, Priority: 5}}},
+		Role{RoleID: "hr_other", Permissions: []Permission{{Path: "hr:profile:*", Regions: []string{"GLOBAL"}}}},
+	)
+	app := fiber.New()
+	newRouter(app).Protected(fiber.MethodGet, "/user/profile",
+		Requirement{Path: "hr:profile:view", Country: "GLOBAL"}, profileHandler)
+
+	for _, tt := range []struct {
+		roles []string
+		want  string
+	}{
+		// The higher-priority grant decides, wherever its role appears in the token.
+		{[]string{"hr_all", "hr_profile"}, "hr_profile"},
+		{[]string{"hr_profile", "hr_all"}, "hr_profile"},
+		// At equal priority the first matching role keeps the grant.
+		{[]string{"hr_other", "hr_all"}, "hr_other"},
+		{[]string{"hr_all", "hr_other"}, "hr_all"},
+	} {
+		token, err := testutil.UnsignedToken(testutil.TokenSpec{Username: "alice", Roles: tt.roles})
+		if err != nil {
+			t.Fatalf("UnsignedToken: %v", err)
+		}
+		req := httptest.NewRequest(fiber.MethodGet, "/user/profile", nil)
+		req.Header.Set("Authorization", testutil.BearerHeader(token))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("GET /user/profile: %v", err)
+		}
+		var body ProfileResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decode profile: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusOK || body.GrantedByRole != tt.want {
+			t.Errorf("roles %v: status %d, granted_by_role %q, want %q", tt.roles, resp.StatusCode, body.GrantedByRole, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// sanctions.go
+//
+// Legally restricted (e.g. sanctioned) countries. A request targeting one is denied by
+// Decide regardless of any grant, and answered with 451 Unavailable For Legal Reasons
+// instead of 403, so clients and compliance reports can tell it apart from an ordinary
+// permission denial.
+
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// legallyRestrictedCode is the denial code of a request targeting a restricted country.
+const legallyRestrictedCode = "LEGALLY_RESTRICTED"
+
+// restrictedCountries is the set of legally restricted ISO-2 codes (SANCTIONED_COUNTRIES).
+var restrictedCountries map[string]struct{}
+
+/*
+initSanctionedCountries reads SANCTIONED_COUNTRIES, a comma-separated list of ISO-2 codes.
+Unknown codes stop the service, since a typo would silently leave a country reachable.
+*/
+func initSanctionedCountries() {
+	set := make(map[string]struct{})
+	for _, code := range strings.Split(os.Getenv("SANCTIONED_COUNTRIES"), ",") {
+		code = normalizeCountry(code)
+		if code == "" {
+			continue
+		}
+		if !isKnownCountry(code) {
+			log.Fatalf("Invalid SANCTIONED_COUNTRIES entry %q: not a known country code", code)
+		}
+		set[code] = struct{}{}
+	}
+	if len(set) > 0 {
+		restrictedCountries = set
+		logInfo("legally restricted countries are denied with 451", "count", len(set))
+	}
+}
+
+/*
+isLegallyRestricted reports whether country is in SANCTIONED_COUNTRIES.
+*/
+func isLegallyRestricted(country string) bool {
+	_, ok := restrictedCountries[normalizeCountry(country)]
+	return ok
+}
@@ -0,0 +1,91 @@
+// seed.go
+//
+// Optional first-boot seeding of default roles and regions (SEED_ON_START=true) so a fresh
+// environment works without manual MongoDB setup. The seed data is embedded from
+// seed/seed.json. Seeding only inserts documents that do not exist yet, keyed by role_id
+// and region, so it is safe to run on every start and never overwrites edited documents.
+
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+//go:embed seed/seed.json
+var seedJSON []byte
+
+// seedData is the shape of the embedded seed file.
+type seedData struct {
+	Roles   []Role             `json:"roles"`
+	Regions []RegionDefinition `json:"regions"`
+}
+
+/*
+initSeed seeds the embedded default roles and regions when SEED_ON_START is true. It must
+run after initMongo and before initRegions so seeded regions are part of the first load.
+*/
+func initSeed() {
+	enabled, _ := strconv.ParseBool(os.Getenv("SEED_ON_START"))
+	if !enabled {
+		return
+	}
+	var data seedData
+	if err := json.Unmarshal(seedJSON, &data); err != nil {
+		log.Fatal("Invalid embedded seed data:", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	roles := mongoDB.Collection("roles")
+	for _, role := range data.Roles {
+		seeded, err := insertIfMissing(ctx, roles, bson.M{"role_id": role.RoleID}, role)
+		if err != nil {
+			log.Fatalf("Failed to seed role '%s': %v", role.RoleID, err)
+		}
+		logSeedResult("role", role.RoleID, seeded)
+	}
+
+	regions := mongoDB.Collection("regions")
+	for _, def := range data.Regions {
+		seeded, err := insertIfMissing(ctx, regions, bson.M{"region": def.Region}, def)
+		if err != nil {
+			log.Fatalf("Failed to seed region '%s': %v", def.Region, err)
+		}
+		logSeedResult("region", def.Region, seeded)
+	}
+}
+
+/*
+insertIfMissing inserts doc unless a document matching filter already exists, using an
+upsert with $setOnInsert so concurrent starts cannot insert duplicates. It reports whether
+the document was inserted.
+*/
+func insertIfMissing(ctx context.Context, coll *mongo.Collection, filter bson.M, doc interface{}) (bool, error) {
+	res, err := coll.UpdateOne(ctx, filter, bson.M{"$setOnInsert": doc}, options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+	return res.UpsertedCount > 0, nil
+}
+
+/*
+logSeedResult logs whether a seed document was inserted or already present.
+*/
+func logSeedResult(kind, key string, seeded bool) {
+	if seeded {
+		logInfo("seeded document", "kind", kind, "key", key)
+		return
+	}
+	logInfo("skipped seeding existing document", "kind", kind, "key", key)
+}
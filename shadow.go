@@ -0,0 +1,182 @@
+// shadow.go
+//
+// Shadow evaluation of candidate role definitions against live traffic. With
+// SHADOW_ROLES_COLLECTION set, every permission check is also evaluated with the role
+// documents from that collection (roles missing there fall back to their enforced
+// definition), and decisions that diverge are logged and counted. The shadow result
+// never affects the response and is computed off the request path, by a fixed pool of
+// workers; checks arriving while the queue is full are dropped and counted.
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// shadowCollection holds the candidate roles. Empty disables shadow evaluation.
+	shadowCollection string
+	// shadowRoles caches candidate roles so shadow checks do not query MongoDB per request.
+	shadowRoles *roleCache
+	// shadowQueue feeds the shadow workers.
+	shadowQueue chan shadowJob
+
+	shadowEvaluations atomic.Uint64
+	shadowGrants      atomic.Uint64
+	shadowRevokes     atomic.Uint64
+	shadowDropped     atomic.Uint64
+)
+
+const (
+	// shadowWorkers is the number of goroutines evaluating shadow checks.
+	shadowWorkers = 4
+	// shadowQueueSize bounds the checks waiting for a worker.
+	shadowQueueSize = 1024
+)
+
+// shadowJob is one permission check queued for shadow evaluation.
+type shadowJob struct {
+	claims   jwt.MapClaims
+	user     *User
+	req      Requirement
+	enforced bool
+}
+
+/*
+initShadowMode enables shadow evaluation when SHADOW_ROLES_COLLECTION names a collection.
+Candidate roles are cached for SHADOW_ROLES_TTL (default 30s) so edits to the shadow
+collection show up quickly.
+*/
+func initShadowMode() {
+	shadowCollection = os.Getenv("SHADOW_ROLES_COLLECTION")
+	if shadowCollection == "" {
+		return
+	}
+	ttl := 30 * time.Second
+	if v := os.Getenv("SHADOW_ROLES_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid SHADOW_ROLES_TTL %q: must be a positive duration such as 30s", v)
+		}
+		ttl = d
+	}
+	shadowRoles = newRoleCache(ttl, 0)
+	shadowQueue = make(chan shadowJob, shadowQueueSize)
+	for i := 0; i < shadowWorkers; i++ {
+		go func() {
+			for job := range shadowQueue {
+				shadowEvaluate(job.claims, job.user, job.req, job.enforced)
+			}
+		}()
+	}
+	registerCounter("rbac_shadow_evaluations_total", "Permission checks also evaluated against shadow roles.", shadowEvaluations.Load)
+	registerCounter("rbac_shadow_divergence_grant_total", "Checks the shadow roles would allow but the enforced roles denied.", shadowGrants.Load)
+	registerCounter("rbac_shadow_divergence_revoke_total", "Checks the shadow roles would deny but the enforced roles allowed.", shadowRevokes.Load)
+	registerCounter("rbac_shadow_dropped_total", "Checks not evaluated in shadow because the queue was full.", shadowDropped.Load)
+	logInfo("shadow mode enabled", "collection", shadowCollection)
+}
+
+/*
+loadShadowRole returns the candidate definition of roleID, or its enforced definition when
+the shadow collection has none.
+*/
+func loadShadowRole(ctx context.Context, roleID string) (Role, error) {
+	if role, ok := shadowRoles.get(roleID); ok {
+		return role, nil
+	}
+	var role Role
+	err := findRoleDocument(ctx, shadowCollection, bson.M{"role_id": roleID}, &role)
+	if err == mongo.ErrNoDocuments {
+		role, err = loadRole(ctx, roleID)
+	}
+	if err != nil {
+		return Role{}, err
+	}
+	role.countries = roleCountrySet(role)
+	shadowRoles.set(roleID, role)
+	return role, nil
+}
+
+/*
+enqueueShadow queues a check for the shadow workers without blocking the request. The
+requirement can hold strings that alias Fiber's request buffers (route parameters), which
+are reused once the handler returns, so they are copied first.
+*/
+func enqueueShadow(claims jwt.MapClaims, user *User, req Requirement, enforced bool) {
+	req.Path = strings.Clone(req.Path)
+	req.Country = strings.Clone(req.Country)
+	req.Owner = strings.Clone(req.Owner)
+	if req.Attributes != nil {
+		attrs := make(map[string]interface{}, len(req.Attributes))
+		for k, v := range req.Attributes {
+			if s, ok := v.(string); ok {
+				v = strings.Clone(s)
+			}
+			attrs[strings.Clone(k)] = v
+		}
+		req.Attributes = attrs
+	}
+	select {
+	case shadowQueue <- shadowJob{claims: claims, user: user, req: req, enforced: enforced}:
+	default:
+		shadowDropped.Add(1)
+	}
+}
+
+/*
+shadowEvaluate evaluates req for the token's roles as defined in the shadow collection and
+records a divergence from the enforced decision. It runs on a shadow worker; failures only
+skip the comparison.
+*/
+func shadowEvaluate(claims jwt.MapClaims, user *User, req Requirement, enforced bool) {
+	roleIDs, err := extractRoleIDs(claims)
+	if err != nil {
+		return
+	}
+	roleIDs, _ = unexpiredRoleIDs(claims, roleIDs)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var roles []Role
+	for _, roleID := range roleIDs {
+		role, err := loadShadowRole(ctx, roleID)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				logWarn("shadow evaluation skipped", "role", roleID, "error", err)
+				return
+			}
+			continue
+		}
+		if !role.Disabled {
+			roles = append(roles, role)
+		}
+	}
+	candidate := userFromRoles(user.ID, roles)
+	candidate.Subject = user.Subject
+	candidate.Audiences = user.Audiences
+	if err := finishUser(ctx, candidate, claims); err != nil {
+		return
+	}
+
+	shadow := IsAllowed(candidate, req)
+	shadowEvaluations.Add(1)
+	if shadow == enforced {
+		return
+	}
+	if shadow {
+		shadowGrants.Add(1)
+	} else {
+		shadowRevokes.Add(1)
+	}
+	logInfo("shadow divergence", "sub", logSafe(user.Subject), "preferred_username", logSafe(user.ID),
+		"path", req.Path, "country", req.Country, "enforced", enforced, "shadow", shadow)
+}
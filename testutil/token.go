@@ -0,0 +1,119 @@
+// token.go
+//
+// JWT builders for exercising the RBAC middleware in tests. Tokens can be unsigned
+// (alg "none") for the default gateway-trust mode, where the backend parses claims
+// without verifying them, or RS256-signed with a throwaway key whose JWKS can be
+// served to the backend in JWT_VERIFY_MODE=jwks.
+
+// Package testutil provides helpers for building test tokens.
+package testutil
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// TokenSpec describes the claims of a test token. Extra claims override the defaults.
+type TokenSpec struct {
+	Username string
+	Subject  string
+	Roles    []string
+	TTL      time.Duration
+	Extra    map[string]interface{}
+}
+
+// TestKey is an RSA signing key with its key id, for signed test tokens.
+type TestKey struct {
+	Kid        string
+	PrivateKey *rsa.PrivateKey
+}
+
+/*
+claims builds the claim set for spec, defaulting the subject to the username and the
+lifetime to five minutes.
+*/
+func (spec TokenSpec) claims() jwt.MapClaims {
+	now := time.Now()
+	ttl := spec.TTL
+	if ttl == 0 {
+		ttl = 5 * time.Minute
+	}
+	subject := spec.Subject
+	if subject == "" {
+		subject = spec.Username
+	}
+	roles := make([]interface{}, len(spec.Roles))
+	for i, r := range spec.Roles {
+		roles[i] = r
+	}
+	claims := jwt.MapClaims{
+		"preferred_username": spec.Username,
+		"sub":                subject,
+		"roles":              roles,
+		"iat":                now.Unix(),
+		"exp":                now.Add(ttl).Unix(),
+	}
+	for k, v := range spec.Extra {
+		claims[k] = v
+	}
+	return claims
+}
+
+/*
+UnsignedToken returns an alg "none" token for spec, suitable for the gateway-trust mode.
+*/
+func UnsignedToken(spec TokenSpec) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, spec.claims())
+	return token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+}
+
+/*
+BearerHeader formats a token as an Authorization header value.
+*/
+func BearerHeader(token string) string {
+	return "Bearer " + token
+}
+
+/*
+NewTestKey generates a fresh 2048-bit RSA key with the given key id.
+*/
+func NewTestKey(kid string) (*TestKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &TestKey{Kid: kid, PrivateKey: key}, nil
+}
+
+/*
+SignedToken returns an RS256 token for spec signed with k, carrying k.Kid in its header.
+*/
+func (k *TestKey) SignedToken(spec TokenSpec) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, spec.claims())
+	token.Header["kid"] = k.Kid
+	return token.SignedString(k.PrivateKey)
+}
+
+/*
+JWKS returns a JWKS document containing the public half of k, to be served as the
+backend's KEYCLOAK_JWKS_URL.
+*/
+func (k *TestKey) JWKS() ([]byte, error) {
+	pub := k.PrivateKey.PublicKey
+	return json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": k.Kid,
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
@@ -0,0 +1,131 @@
+// tls.go
+//
+// Optional direct TLS serving for deployments without a TLS-terminating proxy in front of
+// the service. TLS_CERT_FILE and TLS_KEY_FILE enable it; TLS_MIN_VERSION and
+// TLS_CIPHER_SUITES restrict the protocol for compliance. Fiber's ListenTLS builds its own
+// tls.Config, so the listener is wrapped here and handed to app.Listener instead.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultTLSCipherSuites is the TLS 1.2 cipher set used when TLS_CIPHER_SUITES is unset:
+// forward-secret AEAD suites only.
+var defaultTLSCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// tlsConfig is the server TLS configuration. It is nil when TLS is disabled.
+var tlsConfig *tls.Config
+
+/*
+initTLS builds the server TLS configuration when TLS_CERT_FILE and TLS_KEY_FILE are set.
+TLS_MIN_VERSION is "1.2" (the default) or "1.3". TLS_CIPHER_SUITES is a comma-separated
+list of Go cipher suite names (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) and applies to
+TLS 1.2; Go always uses its own TLS 1.3 suites. Unknown or insecure names stop the service.
+*/
+func initTLS() {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return
+	}
+	if certFile == "" || keyFile == "" {
+		log.Fatal("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		log.Fatal("Failed to load TLS certificate:", err)
+	}
+	minVersion, err := parseTLSVersion(os.Getenv("TLS_MIN_VERSION"))
+	if err != nil {
+		log.Fatalf("Invalid TLS_MIN_VERSION: %v", err)
+	}
+	suites := defaultTLSCipherSuites
+	if v := os.Getenv("TLS_CIPHER_SUITES"); v != "" {
+		if suites, err = parseCipherSuites(v); err != nil {
+			log.Fatalf("Invalid TLS_CIPHER_SUITES: %v", err)
+		}
+	}
+	tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		CipherSuites: suites,
+	}
+	logInfo("TLS enabled", "min_version", tls.VersionName(minVersion), "tls12_cipher_suites", len(suites))
+}
+
+/*
+parseTLSVersion converts a TLS_MIN_VERSION value to a tls version constant. Versions below
+1.2 are refused.
+*/
+func parseTLSVersion(v string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(v)), "TLS") {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("%q: expected 1.2 or 1.3", v)
+}
+
+/*
+parseCipherSuites resolves comma-separated cipher suite names against Go's secure suites.
+Names from tls.InsecureCipherSuites are rejected with a dedicated message.
+*/
+func parseCipherSuites(v string) ([]uint16, error) {
+	secure := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	insecure := make(map[string]bool)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+	var ids []uint16
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if insecure[name] {
+			return nil, fmt.Errorf("%s is insecure", name)
+		}
+		id, ok := secure[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no cipher suites listed in %q", v)
+	}
+	return ids, nil
+}
+
+/*
+listen serves the app on addr, over TLS with tlsConfig when TLS is enabled.
+*/
+func listen(app *fiber.App, addr string) error {
+	if tlsConfig == nil {
+		return app.Listen(addr)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return app.Listener(tls.NewListener(ln, tlsConfig))
+}
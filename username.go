@@ -0,0 +1,66 @@
+// username.go
+//
+// Hardening of the token's preferred_username against log injection. Usernames are always
+// logged and sent to audit sinks with control characters stripped, while decisions keep the
+// original value. USERNAME_PATTERN optionally rejects tokens whose username does not match.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// maxLoggedUsername caps the length of a logged username so a huge claim cannot flood logs.
+const maxLoggedUsername = 256
+
+// usernamePattern, when set, must match the whole preferred_username of every token.
+var usernamePattern *regexp.Regexp
+
+/*
+initUsernamePolicy compiles USERNAME_PATTERN. The pattern is anchored, so "[a-z0-9._-]+"
+must match the entire username.
+*/
+func initUsernamePolicy() {
+	raw := os.Getenv("USERNAME_PATTERN")
+	if raw == "" {
+		return
+	}
+	re, err := regexp.Compile("^(?:" + raw + ")$")
+	if err != nil {
+		log.Fatalf("Invalid USERNAME_PATTERN %q: %v", raw, err)
+	}
+	usernamePattern = re
+	logInfo("rejecting tokens whose preferred_username does not match", "pattern", raw)
+}
+
+/*
+validateUsername rejects a username that fails USERNAME_PATTERN.
+*/
+func validateUsername(username string) error {
+	if usernamePattern != nil && !usernamePattern.MatchString(username) {
+		return fmt.Errorf("preferred_username does not match the allowed pattern")
+	}
+	return nil
+}
+
+/*
+logSafe returns s with control characters (newlines, tabs, escapes) removed and truncated to
+maxLoggedUsername runes, for identity values that end up in logs and audit events.
+*/
+func logSafe(s string) string {
+	clean := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return -1
+		}
+		return r
+	}, s)
+	if runes := []rune(clean); len(runes) > maxLoggedUsername {
+		clean = string(runes[:maxLoggedUsername]) + "..."
+	}
+	return clean
+}
@@ -0,0 +1,184 @@
+// validation.go
+//
+// Structural validation of role permissions as they are loaded, so configuration mistakes
+// (unknown regions, contradictory include/exclude lists, malformed paths) are reported to
+// admins instead of silently changing decisions. ROLE_VALIDATION=strict refuses such roles.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// roleValidationStrict rejects roles with permission issues instead of only logging them.
+var roleValidationStrict bool
+
+/*
+initRoleValidation reads ROLE_VALIDATION (warn, the default, or strict).
+*/
+func initRoleValidation() {
+	switch mode := os.Getenv("ROLE_VALIDATION"); mode {
+	case "", "warn":
+	case "strict":
+		roleValidationStrict = true
+		logInfo("role validation is strict: invalid roles are rejected")
+	default:
+		log.Fatalf("Invalid ROLE_VALIDATION %q: expected warn or strict", mode)
+	}
+}
+
+// emptyCountriesError rejects users whose roles grant no country at all (EMPTY_COUNTRIES_POLICY=error).
+var emptyCountriesError bool
+
+/*
+initEmptyCountriesPolicy reads EMPTY_COUNTRIES_POLICY (warn, the default, or error).
+*/
+func initEmptyCountriesPolicy() {
+	switch mode := os.Getenv("EMPTY_COUNTRIES_POLICY"); mode {
+	case "", "warn":
+	case "error":
+		emptyCountriesError = true
+		logInfo("empty countries policy is error: users whose roles grant no country are rejected")
+	default:
+		log.Fatalf("Invalid EMPTY_COUNTRIES_POLICY %q: expected warn or error", mode)
+	}
+}
+
+/*
+checkUserCountries flags a user who holds roles but no allowed country, which means the
+roles grant paths without any geography. It always logs a warning naming the roles, and
+returns an error under EMPTY_COUNTRIES_POLICY=error.
+*/
+func checkUserCountries(user *User) error {
+	if len(user.Roles) == 0 || len(user.AllowedCountries) > 0 {
+		return nil
+	}
+	ids := make([]string, len(user.Roles))
+	for i, role := range user.Roles {
+		ids[i] = role.RoleID
+	}
+	logWarn("roles grant no countries", "user", logSafe(user.ID), "roles", ids)
+	if emptyCountriesError {
+		return fmt.Errorf("permission check failed: user roles grant no countries")
+	}
+	return nil
+}
+
+/*
+ValidatePermission returns every issue found in a permission: a malformed path or exception
+path, unknown regions or countries, a global grant that also lists countries, countries or
+regions that are both included and excluded, a "*" audience, and invalid attribute
+conditions.
+*/
+func ValidatePermission(perm Permission) []error {
+	var errs []error
+	if err := validatePermissionPath(perm.Path); err != nil {
+		errs = append(errs, fmt.Errorf("path: %v", err))
+	}
+	for _, exPath := range perm.ExceptPaths {
+		if err := validatePermissionPath(exPath); err != nil {
+			errs = append(errs, fmt.Errorf("except_paths: %v", err))
+		}
+	}
+
+	regions := regionMap()
+	global := false
+	for _, r := range perm.Regions {
+		if r == "GLOBAL" || r == "*" {
+			global = true
+		} else if _, ok := regions[r]; !ok {
+			errs = append(errs, fmt.Errorf("regions: unknown region %q", r))
+		}
+	}
+	for _, r := range perm.ExceptRegions {
+		if _, ok := regions[r]; !ok {
+			errs = append(errs, fmt.Errorf("except_regions: unknown region %q", r))
+		}
+		if contains(perm.Regions, r) {
+			errs = append(errs, fmt.Errorf("region %s is both included and excluded; it will be denied", r))
+		}
+	}
+	if global && len(perm.Countries) > 0 {
+		errs = append(errs, fmt.Errorf("countries %v are redundant with a GLOBAL region grant", perm.Countries))
+	}
+	for _, c := range perm.Countries {
+		if c == "*" {
+			continue
+		}
+		if !isKnownCountry(c) {
+			errs = append(errs, fmt.Errorf("countries: unknown country %q", c))
+		}
+		if contains(perm.ExceptCountries, c) {
+			errs = append(errs, fmt.Errorf("country %s is both included and excluded; it will be denied", c))
+		}
+		for _, r := range perm.ExceptRegions {
+			if !explicitCountryBeatsRegionExclusion && containsExact(regions[r], c) {
+				errs = append(errs, fmt.Errorf("country %s is listed but its region %s is excluded; it will be denied (EXCLUSION_PRECEDENCE=strict)", c, r))
+			}
+		}
+	}
+	for _, c := range perm.ExceptCountries {
+		if !isKnownCountry(c) {
+			errs = append(errs, fmt.Errorf("except_countries: unknown country %q", c))
+		}
+	}
+	if containsExact(perm.Audiences, "*") {
+		errs = append(errs, fmt.Errorf(`audiences: "*" is not a wildcard; leave audiences empty to apply to every audience`))
+	}
+	if err := validateConditions(perm.Conditions); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+/*
+validatePermissionPath checks that a permission path pattern is non-empty and has no empty
+segments.
+*/
+func validatePermissionPath(path string) error {
+	if path == "" {
+		return fmt.Errorf("empty path")
+	}
+	for _, segment := range strings.Split(path, pathSeparator) {
+		if segment == "" {
+			return fmt.Errorf("%q has an empty segment", path)
+		}
+	}
+	return nil
+}
+
+/*
+validateRole runs ValidatePermission over every permission of the role and prefixes each
+issue with the permission it belongs to.
+*/
+func validateRole(role Role) []string {
+	var issues []string
+	for i, perm := range role.Permissions {
+		for _, err := range ValidatePermission(perm) {
+			issues = append(issues, fmt.Sprintf("permission %d (%s): %v", i, perm.Path, err))
+		}
+	}
+	return issues
+}
+
+/*
+checkLoadedRole logs a per-role report of permission issues and, in strict mode, returns an
+error so the role is not used.
+*/
+func checkLoadedRole(role Role) error {
+	issues := validateRole(role)
+	if len(issues) == 0 {
+		return nil
+	}
+	logWarn("invalid role permissions", "role", role.RoleID, "issues", len(issues))
+	for _, issue := range issues {
+		logWarn("invalid role permission", "role", role.RoleID, "issue", issue)
+	}
+	if roleValidationStrict {
+		return fmt.Errorf("role %q has %d invalid permissions", role.RoleID, len(issues))
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// webhook.go
+//
+// Optional delivery of access-denial events to an HTTP endpoint (e.g. a SIEM collector)
+// configured with DENIAL_WEBHOOK_URL. Events are queued in a bounded channel and POSTed by
+// a background worker with retries, so requests never wait on the webhook; when the queue
+// is full new events are dropped and counted. With DENIAL_WEBHOOK_SECRET set, each body is
+// signed with HMAC-SHA256 in the X-Signature-256 header ("sha256=<hex>").
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// DenialEvent is the JSON body POSTed for each access denial.
+type DenialEvent struct {
+	User      string    `json:"user"`
+	Subject   string    `json:"sub"`
+	Path      string    `json:"path"`
+	Country   string    `json:"country"`
+	Method    string    `json:"method"`
+	Route     string    `json:"route"`
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// denialWebhook delivers queued denial events to a single URL.
+type denialWebhook struct {
+	url        string
+	secret     []byte
+	queue      chan DenialEvent
+	httpClient *http.Client
+	maxRetries int
+
+	delivered atomic.Uint64
+	failed    atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// webhook is the active denial webhook. It is nil when DENIAL_WEBHOOK_URL is not set.
+var webhook *denialWebhook
+
+/*
+initDenialWebhook starts the webhook worker when DENIAL_WEBHOOK_URL is set. The queue holds
+DENIAL_WEBHOOK_QUEUE_SIZE events (default 1000).
+*/
+func initDenialWebhook() {
+	url := os.Getenv("DENIAL_WEBHOOK_URL")
+	if url == "" {
+		return
+	}
+	size := 1000
+	if v := os.Getenv("DENIAL_WEBHOOK_QUEUE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid DENIAL_WEBHOOK_QUEUE_SIZE %q: must be a positive integer", v)
+		}
+		size = n
+	}
+	w := &denialWebhook{
+		url:        url,
+		secret:     []byte(os.Getenv("DENIAL_WEBHOOK_SECRET")),
+		queue:      make(chan DenialEvent, size),
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		maxRetries: 3,
+	}
+	registerCounter("rbac_denial_webhook_delivered_total", "Denial events delivered to the webhook.", w.delivered.Load)
+	registerCounter("rbac_denial_webhook_failed_total", "Denial events dropped after exhausting retries.", w.failed.Load)
+	registerCounter("rbac_denial_webhook_dropped_total", "Denial events dropped because the queue was full.", w.dropped.Load)
+	registerGauge("rbac_denial_webhook_queue_length", "Denial events waiting for delivery.", func() float64 {
+		return float64(len(w.queue))
+	})
+	webhook = w
+	go w.run()
+	logInfo("denial webhook enabled", "url", url, "queue", size, "signed", len(w.secret) > 0)
+}
+
+/*
+enqueue queues an event without blocking, dropping it when the queue is full.
+*/
+func (w *denialWebhook) enqueue(event DenialEvent) {
+	select {
+	case w.queue <- event:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+/*
+run delivers queued events one at a time, retrying each with exponential backoff
+(1s, 2s, 4s) before giving up on it.
+*/
+func (w *denialWebhook) run() {
+	for event := range w.queue {
+		body, err := json.Marshal(event)
+		if err != nil {
+			w.failed.Add(1)
+			continue
+		}
+		backoff := time.Second
+		for attempt := 0; ; attempt++ {
+			err = w.post(body)
+			if err == nil {
+				w.delivered.Add(1)
+				break
+			}
+			if attempt == w.maxRetries {
+				w.failed.Add(1)
+				logWarn("denial webhook delivery failed", "attempts", attempt+1, "error", err)
+				break
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+/*
+post sends one signed event body. Any non-2xx response counts as a failure.
+*/
+func (w *denialWebhook) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(body)
+		req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}